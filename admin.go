@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// configStore holds the live config. Handlers read it once per request, so a
+// reload never changes the settings of a fetch that is already running.
+type configStore struct {
+	source  string
+	current atomic.Pointer[Config]
+}
+
+func newConfigStore(source string, config Config) *configStore {
+	s := &configStore{source: source}
+	s.current.Store(&config)
+	return s
+}
+
+func (s *configStore) Load() Config {
+	return *s.current.Load()
+}
+
+// startupSettings are read once, when main sets up the listener, temp dirs,
+// limiters, Redis sink, audit log and tracing, so a reload can't change them.
+var startupSettings = []struct {
+	key   string
+	value func(Config) interface{}
+}{
+	{"server.ip", func(c Config) interface{} { return c.Server.IP }},
+	{"server.port", func(c Config) interface{} { return c.Server.Port }},
+	{"server.unix_socket", func(c Config) interface{} { return c.Server.UnixSocket }},
+	{"server.temp_dir", func(c Config) interface{} { return c.Server.TempDir }},
+	{"server.max_concurrent", func(c Config) interface{} { return c.Server.MaxConcurrent }},
+	{"server.max_queue", func(c Config) interface{} { return c.Server.MaxQueue }},
+	{"server.per_domain_concurrency", func(c Config) interface{} { return c.Server.PerDomainConcurrency }},
+	{"server.redis_url", func(c Config) interface{} { return c.Server.RedisURL }},
+	{"server.redis_key_prefix", func(c Config) interface{} { return c.Server.RedisKeyPrefix }},
+	{"server.audit_log", func(c Config) interface{} { return c.Server.AuditLog }},
+	{"server.audit_log_max_mb", func(c Config) interface{} { return c.Server.AuditLogMaxMB }},
+	{"server.audit_log_backups", func(c Config) interface{} { return c.Server.AuditLogBackups }},
+	{"server.otel_endpoint", func(c Config) interface{} { return c.Server.OtelEndpoint }},
+}
+
+// changedStartupSettings lists the startupSettings that differ between the
+// running config and next.
+func changedStartupSettings(running, next Config) []string {
+	var changed []string
+	for _, setting := range startupSettings {
+		if setting.value(running) != setting.value(next) {
+			changed = append(changed, setting.key)
+		}
+	}
+	return changed
+}
+
+// reload re-reads the config from its original source and swaps it in only
+// if it loads, validates and leaves the startupSettings as they are;
+// otherwise the running config stays in place.
+func (s *configStore) reload() (Config, error) {
+	if s.source == "-" {
+		return Config{}, errors.New("config was read from stdin and cannot be reloaded")
+	}
+	config, err := loadConfig(s.source)
+	if err != nil {
+		return Config{}, err
+	}
+	if changed := changedStartupSettings(s.Load(), config); len(changed) > 0 {
+		return Config{}, fmt.Errorf("%s only take effect on restart", strings.Join(changed, ", "))
+	}
+	s.current.Store(&config)
+	return config, nil
+}
+
+// requireAPIKey rejects requests without the configured server.api_key,
+// given as an X-API-Key header, an "Authorization: Bearer" token or, for
+// browsers, the password of basic auth. When no key is configured every
+// request is let through.
+func requireAPIKey(next http.Handler, store *configStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := store.Load()
+		if key := config.Server.APIKey; key != "" && !validAPIKey(r, key) {
+			log.Printf("Rejected request from %s: missing or invalid API key", clientIP(r, config))
+			w.Header().Set("WWW-Authenticate", `Basic realm="cookieapi"`)
+			sendError(w, "Missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validAPIKey(r *http.Request, key string) bool {
+	given := r.Header.Get("X-API-Key")
+	if given == "" {
+		if _, password, ok := r.BasicAuth(); ok {
+			given = password
+		} else {
+			given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1
+}
+
+// handleReloadConfig serves POST /admin/reload-config. It is only available
+// when server.api_key is set, since reloading can change nearly every
+// setting, server.api_key included.
+func handleReloadConfig(w http.ResponseWriter, r *http.Request, store *configStore) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Only POST requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if store.Load().Server.APIKey == "" {
+		sendError(w, "Admin endpoints require server.api_key to be configured", http.StatusForbidden)
+		return
+	}
+
+	if _, err := store.reload(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to reload config, keeping the current one: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Reloaded config from %s", store.source)
+	sendJSONResponse(w, map[string]string{"status": "reloaded", "source": store.source})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	maxAfterEventDelayMS = 60000
+	// afterEventTimeout bounds the wait for a matching response.
+	afterEventTimeout = 30 * time.Second
+)
+
+func validateAfterEvent(o AfterEventOptions) error {
+	if o.URLPattern == "" {
+		return fmt.Errorf("after_event_delay.url_pattern is required")
+	}
+	if _, err := regexp.Compile(o.URLPattern); err != nil {
+		return fmt.Errorf("invalid after_event_delay.url_pattern: %v", err)
+	}
+	if o.DelayMS < 0 || o.DelayMS > maxAfterEventDelayMS {
+		return fmt.Errorf("after_event_delay.delay_ms must be between 0 and %d", maxAfterEventDelayMS)
+	}
+	return nil
+}
+
+// queryAfterEvent reads after_event_pattern and after_event_delay_ms.
+func queryAfterEvent(r *http.Request) *AfterEventOptions {
+	pattern := r.URL.Query().Get("after_event_pattern")
+	if pattern == "" {
+		return nil
+	}
+	return &AfterEventOptions{URLPattern: pattern, DelayMS: queryInt(r, "after_event_delay_ms")}
+}
+
+// eventWatcher remembers when the first matching response arrived. It is
+// started before navigation so responses during page load count too.
+type eventWatcher struct {
+	opts AfterEventOptions
+	seen chan struct{}
+	once sync.Once
+	at   time.Time
+}
+
+func watchForEvent(ctx context.Context, opts AfterEventOptions) (*eventWatcher, error) {
+	re := regexp.MustCompile(opts.URLPattern)
+	w := &eventWatcher{opts: opts, seen: make(chan struct{})}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		e, ok := ev.(*network.EventResponseReceived)
+		if !ok || !re.MatchString(e.Response.URL) {
+			return
+		}
+		w.once.Do(func() {
+			if verbose {
+				log.Printf("Saw response from %s, collecting cookies in %dms", e.Response.URL, opts.DelayMS)
+			}
+			w.at = time.Now()
+			close(w.seen)
+		})
+	})
+	if err := enableNetwork(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// wait returns DelayMS after the matching response, which may already have
+// passed if the response came early in the page load.
+func (w *eventWatcher) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(afterEventTimeout):
+		return fmt.Errorf("%w: no response matching %s within %v", errWaitTimeout, w.opts.URLPattern, afterEventTimeout)
+	case <-w.seen:
+	}
+	remaining := time.Until(w.at.Add(time.Duration(w.opts.DelayMS) * time.Millisecond))
+	if remaining <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(remaining):
+		return nil
+	}
+}
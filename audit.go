@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditLogMaxMB   = 10
+	defaultAuditLogBackups = 5
+)
+
+// rotatingFile is an append-only log file that is rotated once it would
+// grow past maxBytes: path becomes path.1, path.1 becomes path.2 and so on,
+// keeping at most backups old files.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to rotate %s: %v", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+	for i := rf.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.backups > 0 {
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// auditEntry is one line of the audit log. Cookie values are never logged,
+// only how many cookies a response carried. mu guards Cookies, which the
+// handler may still set from withRequestTimeout's goroutine after the
+// middleware has timed it out.
+type auditEntry struct {
+	mu         sync.Mutex
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	ClientIP   string    `json:"client_ip"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"duration_ms"`
+	Cookies    *int      `json:"cookies,omitempty"`
+}
+
+type auditKey struct{}
+
+// noteCookieCount records for the audit log how many cookies the response to
+// r carries.
+func noteCookieCount(r *http.Request, n int) {
+	if entry, ok := r.Context().Value(auditKey{}).(*auditEntry); ok {
+		entry.mu.Lock()
+		entry.Cookies = &n
+		entry.mu.Unlock()
+	}
+}
+
+// auditURL is the request path with the values of its query parameters
+// redacted, as they include the target's own, which may carry tokens.
+func auditURL(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.EscapedPath()
+	}
+	var pairs []string
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		if key, _, ok := strings.Cut(pair, "="); ok {
+			pair = key + "=" + redacted
+		}
+		pairs = append(pairs, pair)
+	}
+	return r.URL.EscapedPath() + "?" + strings.Join(pairs, "&")
+}
+
+// statusRecorder captures the response status for the audit log. It keeps
+// Flush so streaming responses still work through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// newAuditLog opens server.audit_log and returns a middleware writing one
+// JSON line per request to it. It returns the handler unchanged when no
+// audit log is configured.
+func newAuditLog(next http.Handler, config Config, store *configStore) (http.Handler, error) {
+	path := config.Server.AuditLog
+	if path == "" {
+		return next, nil
+	}
+	maxMB := config.Server.AuditLogMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultAuditLogMaxMB
+	}
+	backups := config.Server.AuditLogBackups
+	if backups <= 0 {
+		backups = defaultAuditLogBackups
+	}
+	out, err := openRotatingFile(path, int64(maxMB)<<20, backups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open server.audit_log: %v", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &auditEntry{
+			Time:     start.UTC(),
+			Method:   r.Method,
+			URL:      auditURL(r),
+			ClientIP: clientIP(r, store.Load()),
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.DurationMS = time.Since(start).Milliseconds()
+		entry.mu.Lock()
+		line, err := json.Marshal(entry)
+		entry.mu.Unlock()
+		if err == nil {
+			_, err = out.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("Failed to write audit log: %v", err)
+		}
+	}), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// defaultAuthNamePatterns match the names sessions and tokens are commonly
+// stored under. server.auth_name_patterns replaces them.
+var defaultAuthNamePatterns = []string{
+	`(?i)sess`,
+	`(?i)token`,
+	`(?i)auth`,
+	`(?i)jwt`,
+	`(?i)login`,
+	`(?i)remember`,
+	`(?i)(^|[_.-])sid($|[_.-])`,
+}
+
+// defaultAuthIgnorePatterns match analytics, advertising and consent
+// cookies, which never count as auth cookies however they score otherwise.
+// server.auth_ignore_patterns replaces them.
+var defaultAuthIgnorePatterns = []string{
+	`^_ga`, `^_gid$`, `^_gat`, `^_gcl_`, `^__utm`, `^_fbp$`, `^_fbc$`,
+	`^_hj`, `^_uet`, `^AMCV_`, `^mp_`, `^ajs_`, `^_clck$`, `^_clsk$`,
+	`(?i)consent`, `^OptanonAlertBoxClosed$`, `(?i)cookieyes`,
+}
+
+const (
+	// defaultAuthMinScore is the auth_score auth_only keeps a cookie at,
+	// unless server.auth_min_score says otherwise.
+	defaultAuthMinScore = 0.5
+	// authTokenLen is the value length from which a value looks like a
+	// random token rather than a preference.
+	authTokenLen = 20
+)
+
+// authScorer rates how likely a cookie is to carry authentication.
+type authScorer struct {
+	names, ignore []*regexp.Regexp
+	minScore      float64
+}
+
+func compilePatterns(key string, patterns []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %v", key, p, err)
+		}
+		regexes = append(regexes, re)
+	}
+	return regexes, nil
+}
+
+func newAuthScorer(config Config) (*authScorer, error) {
+	names, ignore := config.Server.AuthNamePatterns, config.Server.AuthIgnorePatterns
+	if names == nil {
+		names = defaultAuthNamePatterns
+	}
+	if ignore == nil {
+		ignore = defaultAuthIgnorePatterns
+	}
+	s := &authScorer{minScore: defaultAuthMinScore}
+	if min := config.Server.AuthMinScore; min != nil {
+		if *min < 0 || *min > 1 {
+			return nil, fmt.Errorf("server.auth_min_score must be between 0 and 1")
+		}
+		s.minScore = *min
+	}
+	var err error
+	if s.names, err = compilePatterns("server.auth_name_patterns", names); err != nil {
+		return nil, err
+	}
+	if s.ignore, err = compilePatterns("server.auth_ignore_patterns", ignore); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// score adds up the signals of an auth cookie: its name (0.5), HttpOnly,
+// which keeps it from scripts (0.2), Secure (0.1) and a token-like value
+// (0.2).
+func (s *authScorer) score(c Cookie) float64 {
+	if matchesAny(s.ignore, c.Name) {
+		return 0
+	}
+	var score float64
+	if matchesAny(s.names, c.Name) {
+		score += 0.5
+	}
+	if c.HTTPOnly {
+		score += 0.2
+	}
+	if c.Secure {
+		score += 0.1
+	}
+	if len(c.Value) >= authTokenLen {
+		score += 0.2
+	}
+	return math.Round(score*100) / 100
+}
+
+// authCookies keeps the cookies scoring at least the minimum, most likely
+// first, with their score in AuthScore.
+func authCookies(cookies []Cookie, config Config) ([]Cookie, error) {
+	s, err := newAuthScorer(config)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Cookie
+	for _, c := range cookies {
+		if c.AuthScore = s.score(c); c.AuthScore >= s.minScore {
+			kept = append(kept, c)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].AuthScore > kept[j].AuthScore })
+	return kept, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// autoAcceptTimeout is how long auto_accept_cookies looks for a banner.
+	// Most pages have none, so not finding one isn't an error.
+	autoAcceptTimeout = 5 * time.Second
+	// autoAcceptPoll is the interval between looks.
+	autoAcceptPoll = 250 * time.Millisecond
+)
+
+// consentSelectors are the accept buttons of common consent platforms,
+// tried in order before consentTexts. chrome.consent_selectors extends them.
+var consentSelectors = []string{
+	"#onetrust-accept-btn-handler",                           // OneTrust
+	"#CybotCookiebotDialogBodyLevelButtonLevelOptinAllowAll", // Cookiebot
+	"#CybotCookiebotDialogBodyButtonAccept",                  // Cookiebot, older
+	".qc-cmp2-summary-buttons button[mode=primary]",          // Quantcast
+	"#didomi-notice-agree-button",                            // Didomi
+	".fc-cta-consent",                                        // Google Funding Choices
+	"#truste-consent-button",                                 // TrustArc
+	".cky-btn-accept",                                        // CookieYes
+	".osano-cm-accept-all",                                   // Osano
+	".cc-btn.cc-allow",                                       // Cookie Consent
+	".iubenda-cs-accept-btn",                                 // iubenda
+	"#axeptio_btn_acceptAll",                                 // Axeptio
+}
+
+// consentTexts are matched, case-insensitively and in order, against the
+// whole label of buttons and links when no selector matched.
+// chrome.consent_texts extends them.
+var consentTexts = []string{
+	"accept all cookies",
+	"accept all",
+	"allow all cookies",
+	"allow all",
+	"accept cookies",
+	"i agree",
+	"agree",
+	"accept",
+	"alle akzeptieren",
+	"tout accepter",
+	"aceptar todo",
+	"accetta tutti",
+}
+
+// autoAcceptScript clicks the first visible element matching a selector,
+// then the first button whose label equals a text, and returns what it
+// clicked or "" if nothing matched. Invalid selectors from the config are
+// skipped rather than aborting the search.
+const autoAcceptScript = `((selectors, texts) => {
+	const visible = (el) => el.getClientRects().length > 0;
+	for (const sel of selectors) {
+		let el;
+		try { el = document.querySelector(sel); } catch (e) { continue; }
+		if (el && visible(el)) { el.click(); return sel; }
+	}
+	const buttons = Array.from(document.querySelectorAll(
+		'button, a, [role=button], input[type=button], input[type=submit]')).filter(visible);
+	for (const text of texts) {
+		for (const el of buttons) {
+			const label = (el.value || el.textContent || "").trim().toLowerCase();
+			if (label === text) { el.click(); return "text " + JSON.stringify(text); }
+		}
+	}
+	return "";
+})(%s, %s)`
+
+// autoAcceptCookies looks for a consent banner for up to autoAcceptTimeout
+// and clicks its accept button. Banners inside cross-origin iframes can't
+// be reached; use click_selectors with a frame-free alternative for those.
+func autoAcceptCookies(ctx context.Context, config Config) error {
+	selectors, err := json.Marshal(append(append([]string{}, consentSelectors...), config.Chrome.ConsentSelectors...))
+	if err != nil {
+		return err
+	}
+	texts := append([]string{}, consentTexts...)
+	for _, t := range config.Chrome.ConsentTexts {
+		texts = append(texts, strings.ToLower(strings.TrimSpace(t)))
+	}
+	textsJSON, err := json.Marshal(texts)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(autoAcceptScript, selectors, textsJSON)
+
+	deadline := time.Now().Add(autoAcceptTimeout)
+	for {
+		var clicked string
+		if err := chromedp.Evaluate(script, &clicked).Do(ctx); err != nil {
+			return fmt.Errorf("failed to look for a consent banner: %v", err)
+		}
+		if clicked != "" {
+			if verbose {
+				log.Printf("Accepted cookies via %s", clicked)
+			}
+			// Let the banner set its consent cookie before moving on.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(clickDelay):
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if verbose {
+				log.Printf("No consent banner found within %v", autoAcceptTimeout)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(autoAcceptPoll):
+		}
+	}
+}
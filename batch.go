@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBatchURLs caps how many URLs a single batch request may list.
+	maxBatchURLs = 100
+	// defaultMaxBatchConcurrency bounds batch_concurrency unless
+	// server.max_batch_concurrency says otherwise.
+	defaultMaxBatchConcurrency = 4
+	minPerURLTimeoutMS         = 1000
+	maxPerURLTimeoutMS         = 600000
+)
+
+const ndjsonMediaType = "application/x-ndjson"
+
+// BatchResult is the outcome of one URL of a batch request. Exactly one of
+// Cookies or Error is meaningful.
+type BatchResult struct {
+	URL     string   `json:"url"`
+	Cookies []Cookie `json:"cookies"`
+	Error   string   `json:"error,omitempty"`
+	Code    string   `json:"code,omitempty"`
+	// RedisKey is where the cookies were exported to server.redis_url.
+	RedisKey string `json:"redis_key,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Partial  bool   `json:"partial,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+	// DurationMS is how long this URL took to fetch, queueing included.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// validateBatchOptions checks batch_concurrency and per_url_timeout_ms,
+// which only apply to batches.
+func validateBatchOptions(payload RequestPayload, config Config) error {
+	if len(payload.URLs) == 0 {
+		if payload.BatchConcurrency != 0 || payload.PerURLTimeoutMS != 0 {
+			return fmt.Errorf("batch_concurrency and per_url_timeout_ms require urls")
+		}
+		return nil
+	}
+	max := config.Server.MaxBatchConcurrency
+	if max <= 0 {
+		max = defaultMaxBatchConcurrency
+	}
+	if payload.BatchConcurrency < 0 || payload.BatchConcurrency > max {
+		return fmt.Errorf("batch_concurrency must be between 1 and %d", max)
+	}
+	if ms := payload.PerURLTimeoutMS; ms != 0 && (ms < minPerURLTimeoutMS || ms > maxPerURLTimeoutMS) {
+		return fmt.Errorf("per_url_timeout_ms must be between %d and %d", minPerURLTimeoutMS, maxPerURLTimeoutMS)
+	}
+	return nil
+}
+
+// serveBatch fetches every URL of payload.URLs with the payload's options,
+// batch_concurrency of them at a time. Results are returned as one JSON
+// array in the order of urls, or with Accept: application/x-ndjson as one
+// JSON object per line, each flushed as soon as its URL completes.
+func serveBatch(w http.ResponseWriter, r *http.Request, payload RequestPayload, config Config) {
+	flusher, canFlush := w.(http.Flusher)
+	stream := strings.Contains(r.Header.Get("Accept"), ndjsonMediaType) && canFlush
+
+	results := make([]BatchResult, len(payload.URLs))
+	if stream {
+		w.Header().Set("Content-Type", ndjsonMediaType)
+	}
+	enc := json.NewEncoder(w)
+	workers := payload.BatchConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+	var mu sync.Mutex
+	streamFailed := false
+	var wg sync.WaitGroup
+	for i, target := range payload.URLs {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := streamFailed
+		mu.Unlock()
+		if failed {
+			break
+		}
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := fetchBatchItem(r.Context(), payload, target, config)
+			if !stream {
+				results[i] = result
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if streamFailed {
+				return
+			}
+			if err := enc.Encode(result); err != nil {
+				log.Printf("Failed to stream batch result for %s: %v", result.URL, err)
+				streamFailed = true
+				return
+			}
+			flusher.Flush()
+		}(i, target)
+	}
+	wg.Wait()
+	if !stream {
+		writeSigned(w, config, func(w http.ResponseWriter) {
+			sendJSONResponse(w, results)
+		})
+	}
+}
+
+func fetchBatchItem(ctx context.Context, payload RequestPayload, target string, config Config) BatchResult {
+	payload.URL, payload.schemeAdded = ensureHTTPS(target), !hasScheme(target)
+	payload.URLs = nil
+	payload.urlTimeout = time.Duration(payload.PerURLTimeoutMS) * time.Millisecond
+	if verbose {
+		log.Printf("Processing batch URL: %s", payload.URL)
+	}
+
+	start := time.Now()
+	result, err := fetchCookiesShared(ctx, payload, config)
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		log.Printf("Error: Failed to fetch cookies for %s: %v", payload.URL, err)
+		return BatchResult{
+			URL:        payload.URL,
+			Error:      fmt.Sprintf("Failed to fetch cookies: %v", err),
+			Code:       errorCode(err),
+			DurationMS: elapsed,
+		}
+	}
+	cookies := result.Cookies
+	if cookies == nil {
+		cookies = []Cookie{}
+	}
+	return BatchResult{
+		URL:        payload.URL,
+		Cookies:    cookies,
+		RedisKey:   redisSink.export(payload.URL, cookies),
+		Attempts:   result.Attempts,
+		Partial:    result.Partial,
+		Warning:    result.Warning,
+		DurationMS: elapsed,
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+)
+
+// clearBrowserCookies deletes every cookie in the browser except those whose
+// name is listed in keep, which are read first and restored afterwards.
+func clearBrowserCookies(ctx context.Context, keep []string) error {
+	var preserved []*network.CookieParam
+	if len(keep) > 0 {
+		all, err := storage.GetCookies().Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read cookies to preserve: %v", err)
+		}
+		keepNames := make(map[string]bool, len(keep))
+		for _, name := range keep {
+			keepNames[name] = true
+		}
+		for _, c := range all {
+			if keepNames[c.Name] {
+				preserved = append(preserved, cookieParam(c))
+			}
+		}
+	}
+
+	if err := network.ClearBrowserCookies().Do(ctx); err != nil {
+		return fmt.Errorf("failed to clear cookies: %v", err)
+	}
+	if len(preserved) > 0 {
+		if err := network.SetCookies(preserved).Do(ctx); err != nil {
+			return fmt.Errorf("failed to restore preserved cookies: %v", err)
+		}
+	}
+	if verbose {
+		log.Printf("Cleared browser cookies, preserved %d", len(preserved))
+	}
+	return nil
+}
+
+// resetFullProfile clears the browser cookies when more than max have
+// piled up in a persistent profile over earlier fetches, before this fetch
+// navigates anywhere.
+func resetFullProfile(ctx context.Context, max int, profile string) error {
+	all, err := storage.GetCookies().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count profile cookies: %v", err)
+	}
+	if len(all) <= max {
+		return nil
+	}
+	log.Printf("Profile %s holds %d cookies, more than chrome.max_profile_cookies (%d); clearing them", profile, len(all), max)
+	if err := network.ClearBrowserCookies().Do(ctx); err != nil {
+		return fmt.Errorf("failed to clear profile cookies: %v", err)
+	}
+	return nil
+}
+
+// cookieParam converts a cookie read from the browser into the form needed
+// to set it again with the same scope.
+func cookieParam(c *network.Cookie) *network.CookieParam {
+	p := &network.CookieParam{
+		Name:         c.Name,
+		Value:        c.Value,
+		Path:         c.Path,
+		Secure:       c.Secure,
+		HTTPOnly:     c.HTTPOnly,
+		SameSite:     c.SameSite,
+		Priority:     c.Priority,
+		SourceScheme: c.SourceScheme,
+		SourcePort:   c.SourcePort,
+		PartitionKey: c.PartitionKey,
+	}
+	// Passing a Domain always yields a domain cookie, so host-only cookies
+	// are scoped through a URL on their host instead.
+	if isHostOnly(c.Domain) {
+		scheme := "http"
+		if c.Secure || c.SourceScheme == network.CookieSourceSchemeSecure {
+			scheme = "https"
+		}
+		p.URL = scheme + "://" + c.Domain + c.Path
+	} else {
+		p.Domain = strings.TrimPrefix(c.Domain, ".")
+	}
+	if !c.Session && c.Expires > 0 {
+		expires := cdp.TimeSinceEpoch(time.Unix(0, int64(c.Expires*float64(time.Second))))
+		p.Expires = &expires
+	}
+	return p
+}
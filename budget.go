@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	minTimeoutMS = 1000
+	maxTimeoutMS = int(interactiveTimeout / time.Millisecond)
+
+	// budgetGrace is added to timeout_ms for the steps after the waits,
+	// such as reading the cookies, so they aren't cut off by the budget.
+	budgetGrace = 5 * time.Second
+)
+
+// Weights of the stages sharing a timeout_ms budget.
+const (
+	navigationWeight  = 2
+	patternWaitWeight = 2
+	idleWaitWeight    = 1
+)
+
+func validateTimeoutMS(ms int, interactive bool) error {
+	if ms == 0 {
+		return nil
+	}
+	if interactive {
+		return fmt.Errorf("timeout_ms can't be combined with interactive, which has a fixed timeout")
+	}
+	if ms < minTimeoutMS || ms > maxTimeoutMS {
+		return fmt.Errorf("timeout_ms must be between %d and %d", minTimeoutMS, maxTimeoutMS)
+	}
+	return nil
+}
+
+// stageBudget divides a total timeout between the stages of a fetch. Each
+// stage is allotted its weighted share of the time still left among the
+// stages that haven't started, so time a fast stage doesn't use carries over
+// to the later ones while a slow one can't take all of it.
+type stageBudget struct {
+	deadline time.Time
+	pending  int
+}
+
+// newStageBudget starts a budget of total at the current time for stages of
+// the given weights. It returns nil when total is zero.
+func newStageBudget(total time.Duration, weights ...int) *stageBudget {
+	if total <= 0 {
+		return nil
+	}
+	b := &stageBudget{deadline: time.Now().Add(total)}
+	for _, w := range weights {
+		b.pending += w
+	}
+	return b
+}
+
+// allot returns the time for the named stage of the given weight, or
+// fallback on a nil budget.
+func (b *stageBudget) allot(stage string, weight int, fallback time.Duration) time.Duration {
+	if b == nil {
+		return fallback
+	}
+	left := time.Until(b.deadline)
+	share := left
+	if b.pending > weight {
+		share = left * time.Duration(weight) / time.Duration(b.pending)
+	}
+	b.pending -= weight
+	if share < 0 {
+		share = 0
+	}
+	if verbose {
+		log.Printf("Allotting %v of the remaining %v to %s", share.Round(time.Millisecond), left.Round(time.Millisecond), stage)
+	}
+	return share
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// maxChromeFlags caps how many chrome_flags a request may pass.
+const maxChromeFlags = 20
+
+// parseChromeFlag splits "--name=value" or "--name" into the flag name and
+// its value, true for flags without one.
+func parseChromeFlag(flag string) (name string, value interface{}, ok bool) {
+	rest, ok := strings.CutPrefix(flag, "--")
+	if !ok || rest == "" {
+		return "", nil, false
+	}
+	if name, v, hasValue := strings.Cut(rest, "="); hasValue {
+		return name, v, name != ""
+	}
+	return rest, true, true
+}
+
+// validateChromeFlags checks chrome_flags against
+// server.allowed_request_flags. Flags are matched by name only, so allowing
+// lang permits any --lang=... value.
+func validateChromeFlags(flags []string, config Config) error {
+	if len(flags) == 0 {
+		return nil
+	}
+	if config.Chrome.RemoteWSURL != "" {
+		return fmt.Errorf("chrome_flags can't be used with chrome.remote_ws_url, which doesn't launch Chrome")
+	}
+	if len(flags) > maxChromeFlags {
+		return fmt.Errorf("at most %d chrome_flags are allowed", maxChromeFlags)
+	}
+	allowed := make(map[string]bool, len(config.Server.AllowedRequestFlags))
+	for _, name := range config.Server.AllowedRequestFlags {
+		allowed[strings.TrimPrefix(name, "--")] = true
+	}
+	for _, flag := range flags {
+		name, _, ok := parseChromeFlag(flag)
+		if !ok {
+			return fmt.Errorf("invalid chrome flag %q: expected --name or --name=value", flag)
+		}
+		if !allowed[name] {
+			return fmt.Errorf("chrome flag --%s is not in server.allowed_request_flags", name)
+		}
+	}
+	return nil
+}
+
+// chromeFlagOptions turns validated chrome_flags into allocator options.
+func chromeFlagOptions(flags []string) []chromedp.ExecAllocatorOption {
+	opts := make([]chromedp.ExecAllocatorOption, 0, len(flags))
+	for _, flag := range flags {
+		name, value, _ := parseChromeFlag(flag)
+		opts = append(opts, chromedp.Flag(name, value))
+	}
+	return opts
+}
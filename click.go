@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// maxClickSelectors bounds click_selectors.
+	maxClickSelectors = 20
+	// clickTimeout is how long each selector may take to appear.
+	clickTimeout = 5 * time.Second
+	// clickDelay gives the page time to react, e.g. to set the consent
+	// cookie or reveal the next dialog, before the next click.
+	clickDelay = 500 * time.Millisecond
+)
+
+func validateClickSelectors(selectors []string) error {
+	if len(selectors) > maxClickSelectors {
+		return fmt.Errorf("click_selectors allows at most %d selectors", maxClickSelectors)
+	}
+	for _, sel := range selectors {
+		if sel == "" {
+			return fmt.Errorf("click_selectors must not contain empty selectors")
+		}
+	}
+	return nil
+}
+
+// clickSelectors clicks each selector in order, such as the buttons of a
+// cookie banner or age gate. With optional, selectors that don't show up
+// within clickTimeout are skipped instead of failing the fetch.
+func clickSelectors(ctx context.Context, selectors []string, optional bool) error {
+	for i, sel := range selectors {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(clickDelay):
+			}
+		}
+
+		clickCtx, cancel := context.WithTimeout(ctx, clickTimeout)
+		err := chromedp.Click(sel, chromedp.ByQuery, chromedp.NodeVisible).Do(clickCtx)
+		cancel()
+		switch {
+		case err == nil:
+			if verbose {
+				log.Printf("Clicked %s", sel)
+			}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case optional:
+			if verbose {
+				log.Printf("Skipping %s: not clickable within %v", sel, clickTimeout)
+			}
+		default:
+			return fmt.Errorf("failed to click %s: %v", sel, err)
+		}
+	}
+	// Let the last click take effect too before the page is inspected.
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(clickDelay):
+	}
+	return nil
+}
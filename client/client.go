@@ -0,0 +1,103 @@
+// Package client calls a cookieapi server from Go. Its types are the ones
+// the server itself decodes requests into and encodes responses from.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody bounds how much of an error response is kept as its message.
+const maxErrorBody = 4096
+
+// Client sends fetch requests to the server at BaseURL, e.g.
+// http://localhost:8080.
+type Client struct {
+	BaseURL string
+	// APIKey is sent as X-API-Key when the server has server.api_key set.
+	APIKey string
+	// HTTPClient defaults to http.DefaultClient. Fetches can take a minute
+	// or longer, so its Timeout must leave room for them.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: apiKey}
+}
+
+// Bool returns a pointer to v, for RequestPayload.Headless.
+func Bool(v bool) *bool { return &v }
+
+// Error is a failed request. Code is the stable X-Error-Code the server
+// sets for failures clients may want to handle, e.g. PROFILE_LOCKED, and
+// empty otherwise.
+type Error struct {
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("cookieapi: %s (%d %s)", e.Message, e.Status, e.Code)
+	}
+	return fmt.Sprintf("cookieapi: %s (%d)", e.Message, e.Status)
+}
+
+// FetchCookies fetches the cookies of url, with the options in opts, which
+// may be nil. The result is always the envelope; its URLs field must be
+// empty, as batches have a response of their own.
+func (c *Client) FetchCookies(ctx context.Context, url string, opts *RequestPayload) (*Envelope, error) {
+	var payload RequestPayload
+	if opts != nil {
+		payload = *opts
+	}
+	if len(payload.URLs) > 0 {
+		return nil, fmt.Errorf("cookieapi: FetchCookies fetches a single URL, not a batch")
+	}
+	payload.URL = url
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("cookieapi: failed to encode request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/fetch-cookies/?envelope=true", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("cookieapi: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cookieapi: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, &Error{
+			Status:    resp.StatusCode,
+			Code:      resp.Header.Get("X-Error-Code"),
+			Message:   strings.TrimSpace(string(msg)),
+			RequestID: resp.Header.Get("X-Request-ID"),
+		}
+	}
+	var env Envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("cookieapi: failed to decode response: %v", err)
+	}
+	return &env, nil
+}
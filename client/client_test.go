@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchCookiesDecodesEnvelope(t *testing.T) {
+	var got RequestPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/fetch-cookies/" || r.URL.Query().Get("envelope") != "true" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if key := r.Header.Get("X-API-Key"); key != "secret" {
+			t.Errorf("X-API-Key = %q, want secret", key)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":1,"url":"https://example.com","count":1,
+			"cookies":[{"id":"abc","name":"session","value":"v","domain":".example.com","path":"/","expires":-1,"secure":true,"http_only":true,"host_only":false}],
+			"matched_by":"pattern","partial":true,"warning":"network idle timed out"}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "secret")
+	env, err := c.FetchCookies(context.Background(), "example.com", &RequestPayload{Pattern: "dashboard"})
+	if err != nil {
+		t.Fatalf("FetchCookies: %v", err)
+	}
+	if got.URL != "example.com" || got.Pattern != "dashboard" {
+		t.Errorf("sent url %q, pattern %q", got.URL, got.Pattern)
+	}
+	if got.Headless != nil {
+		t.Errorf("sent headless %v, want it omitted", *got.Headless)
+	}
+	if env.Version != 1 || env.Count != 1 || env.MatchedBy != "pattern" || !env.Partial || env.Warning == "" {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+	want := Cookie{ID: "abc", Name: "session", Value: "v", Domain: ".example.com", Path: "/", Expires: -1, Secure: true, HTTPOnly: true}
+	if len(env.Cookies) != 1 || env.Cookies[0] != want {
+		t.Errorf("cookies = %+v, want [%+v]", env.Cookies, want)
+	}
+}
+
+func TestFetchCookiesSendsHeadless(t *testing.T) {
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"version":1,"cookies":[]}`))
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, "").FetchCookies(context.Background(), "example.com", &RequestPayload{Headless: Bool(false)}); err != nil {
+		t.Fatalf("FetchCookies: %v", err)
+	}
+	if v, ok := body["headless"]; !ok || v != false {
+		t.Errorf("headless = %v (present %t), want false", v, ok)
+	}
+}
+
+func TestFetchCookiesMapsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Error-Code", "PROFILE_LOCKED")
+		w.Header().Set("X-Request-ID", "req42")
+		http.Error(w, "Failed to fetch cookies: profile is locked", http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "").FetchCookies(context.Background(), "example.com", nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if apiErr.Status != http.StatusConflict || apiErr.Code != "PROFILE_LOCKED" || apiErr.RequestID != "req42" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+	if apiErr.Message != "Failed to fetch cookies: profile is locked" {
+		t.Errorf("message = %q", apiErr.Message)
+	}
+}
+
+func TestFetchCookiesUncodedError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid request: bad pattern", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "").FetchCookies(context.Background(), "example.com", nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Code != "" {
+		t.Fatalf("err = %v, want an uncoded 400 *Error", err)
+	}
+	if want := "cookieapi: Invalid request: bad pattern (400)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestFetchCookiesRejectsBatch(t *testing.T) {
+	c := New("http://127.0.0.1:0", "")
+	if _, err := c.FetchCookies(context.Background(), "example.com", &RequestPayload{URLs: []string{"a.com"}}); err == nil {
+		t.Fatal("FetchCookies with URLs succeeded, want an error")
+	}
+}
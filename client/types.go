@@ -0,0 +1,304 @@
+package client
+
+import "encoding/json"
+
+// RequestPayload holds the options of a fetch, as sent in the body of
+// POST /fetch-cookies/. Zero values leave the server's defaults in place.
+type RequestPayload struct {
+	URL     string   `json:"url"`
+	URLs    []string `json:"urls"`
+	Pattern string   `json:"pattern"`
+	// WaitSelector waits for a visible element; combined with Pattern the
+	// first of the two to be met ends the wait.
+	WaitSelector string `json:"wait_selector"`
+	// Headless defaults to true when nil.
+	Headless        *bool    `json:"headless,omitempty"`
+	SkipNetworkIdle bool     `json:"skip_network_idle"`
+	Interactive     bool     `json:"interactive"`
+	AcceptLanguage  string   `json:"accept_language"`
+	AcceptEncoding  string   `json:"accept_encoding"`
+	ClearCookies    bool     `json:"clear_cookies"`
+	ClearExcept     []string `json:"clear_except"`
+	IncludePageInfo bool     `json:"include_page_info"`
+	Referrer        string   `json:"referrer"`
+	Origin          string   `json:"origin"`
+	NamePattern     string   `json:"name_pattern"`
+	NamePrefix      string   `json:"name_prefix"`
+	NameContains    string   `json:"name_contains"`
+	ValuePattern    string   `json:"value_pattern"`
+	CaseInsensitive bool     `json:"case_insensitive"`
+	// Path keeps the cookies that would be sent for this request path.
+	Path           string `json:"path"`
+	OnlyPersistent bool   `json:"only_persistent"`
+	OnlySession    bool   `json:"only_session"`
+	// Scroll, when set, scrolls the page to trigger lazy content before
+	// waiting for network idle.
+	Scroll *ScrollOptions `json:"scroll"`
+	// WaitMinCookies waits until at least this many cookies, or of those
+	// matching WaitMinCookiesPattern, are set.
+	WaitMinCookies        int    `json:"wait_min_cookies"`
+	WaitMinCookiesPattern string `json:"wait_min_cookies_pattern"`
+	// WaitResource waits for a request whose URL contains it to finish
+	// loading, for consent scripts that set cookies once it has.
+	WaitResource string `json:"wait_resource"`
+	// BatchConcurrency is how many URLs of a batch are fetched at once,
+	// each within PerURLTimeoutMS.
+	BatchConcurrency int `json:"batch_concurrency"`
+	PerURLTimeoutMS  int `json:"per_url_timeout_ms"`
+	// Profile selects an entry of chrome.profiles; empty uses profile_dir.
+	Profile string `json:"profile"`
+	// WarmupURL is visited first, in the same browser, for sites that only
+	// issue their cookies on a second visit.
+	WarmupURL string `json:"warmup_url"`
+	// NetworkIdleInflight is how many requests may still be outstanding
+	// for the network to count as idle.
+	NetworkIdleInflight int `json:"network_idle_inflight"`
+	// LifecycleEvent, when set, waits for this Chrome lifecycle event of
+	// the page instead of the network idle heuristic.
+	LifecycleEvent string `json:"lifecycle_event"`
+	// AutoAcceptCookies clicks the accept button of a recognised consent
+	// banner, without per-site selectors.
+	AutoAcceptCookies bool `json:"auto_accept_cookies"`
+	// ClickSelectors are clicked in order once the page has loaded, e.g.
+	// to accept a cookie banner.
+	ClickSelectors []string `json:"click_selectors"`
+	// ClickOptional skips click selectors that never appear.
+	ClickOptional bool `json:"click_optional"`
+	// ExtractGlobals lists JavaScript property paths whose values are
+	// returned alongside the cookies.
+	ExtractGlobals []string `json:"extract_globals"`
+	// NavMethod POST sends the navigation to the target as a POST of
+	// NavBody, for login endpoints that need one.
+	NavMethod      string `json:"nav_method"`
+	NavBody        string `json:"nav_body"`
+	NavContentType string `json:"nav_content_type"`
+	// ChromeFlags are extra command-line flags for this fetch's Chrome, each
+	// allowed by server.allowed_request_flags.
+	ChromeFlags []string `json:"chrome_flags"`
+	// SeedCookies, e.g. from an earlier fetch, are set in the browser before
+	// navigating, taking precedence over the profile's own.
+	SeedCookies []Cookie `json:"seed_cookies"`
+	// IncludeIndexedDB lists the IndexedDB databases of the page's origin
+	// alongside the cookies.
+	IncludeIndexedDB bool `json:"include_indexeddb"`
+	// IncludeHTML returns the rendered DOM, at most HTMLMaxBytes of it,
+	// for debugging pages that don't set the expected cookies.
+	IncludeHTML  bool `json:"include_html"`
+	HTMLMaxBytes int  `json:"html_max_bytes"`
+	// AfterEvent, when set, collects cookies a delay after a matching
+	// response instead of right away.
+	AfterEvent *AfterEventOptions `json:"after_event_delay"`
+	// NewContext runs the fetch in a fresh, incognito-like browser context
+	// instead of the profile's own.
+	NewContext bool `json:"new_context"`
+	// NoDedup opts out of sharing the result of an identical request that
+	// is already in flight.
+	NoDedup bool `json:"no_dedup"`
+	// BestEffort returns the cookies collected so far, marked partial,
+	// when the pattern or idle wait times out.
+	BestEffort bool `json:"best_effort"`
+	// TimeoutMS replaces the fixed timeouts with a single budget divided
+	// between navigation, the pattern wait and the idle wait.
+	TimeoutMS int `json:"timeout_ms"`
+	// Fingerprint overrides the user agent, platform, client hints and
+	// languages the browser reports.
+	Fingerprint *FingerprintOptions `json:"fingerprint"`
+	// RetryIfEmpty re-navigates while the fetch collects no cookies, or
+	// lacks required ones.
+	RetryIfEmpty *RetryOptions `json:"retry_if_empty"`
+	// AuthOnly keeps only the cookies that look like they carry a session
+	// or token, scored by heuristics.
+	AuthOnly bool `json:"auth_only"`
+	// EncodeBinaryValues base64-encodes values that aren't printable text.
+	EncodeBinaryValues bool `json:"encode_binary_values"`
+}
+
+// ScrollOptions makes the fetch scroll to the bottom of the page repeatedly
+// so lazily loaded content, and the cookies it sets, get a chance to load.
+type ScrollOptions struct {
+	// MaxScrolls bounds the number of scrolls; scrolling stops earlier once
+	// the page height no longer grows.
+	MaxScrolls int `json:"max_scrolls"`
+	// StepDelayMS is how long to wait after each scroll for content to load.
+	StepDelayMS int `json:"step_delay_ms"`
+}
+
+// AfterEventOptions delays cookie collection until DelayMS after the page
+// received a response whose URL matches URLPattern, for cookies that are
+// rotated some time after a specific request.
+type AfterEventOptions struct {
+	URLPattern string `json:"url_pattern"`
+	DelayMS    int    `json:"delay_ms"`
+}
+
+// RetryOptions re-navigates to the target when the fetch collected no
+// cookies, or none named in Require, for pages whose late scripts only set
+// them some time after they look loaded.
+type RetryOptions struct {
+	// MaxAttempts counts the first navigation too.
+	MaxAttempts int `json:"max_attempts"`
+	// DelayMS is how long to wait before each re-navigation.
+	DelayMS int `json:"delay_ms"`
+	// Require names cookies that must all be present, instead of just any.
+	Require []string `json:"require"`
+}
+
+// FingerprintOptions overrides what the browser reports about itself, its
+// platform and languages, as one coherent profile. A preset supplies every
+// field; the fields given next to it take precedence.
+type FingerprintOptions struct {
+	Preset    string `json:"preset"`
+	UserAgent string `json:"user_agent"`
+	// Platform is navigator.platform, e.g. Win32 or MacIntel.
+	Platform string `json:"platform"`
+	// Languages is navigator.languages; it also sets Accept-Language
+	// unless accept_language is given.
+	Languages []string `json:"languages"`
+	// ClientHints are the User-Agent Client Hints. Without them, as for
+	// Safari, no Sec-CH-UA headers are sent.
+	ClientHints *ClientHints `json:"client_hints"`
+}
+
+// ClientHints are the Sec-CH-UA values and navigator.userAgentData.
+type ClientHints struct {
+	Brands          []BrandVersion `json:"brands"`
+	FullVersionList []BrandVersion `json:"full_version_list"`
+	// Platform is Sec-CH-UA-Platform, e.g. Windows or macOS.
+	Platform        string `json:"platform"`
+	PlatformVersion string `json:"platform_version"`
+	Architecture    string `json:"architecture"`
+	Bitness         string `json:"bitness"`
+	Model           string `json:"model"`
+	Mobile          bool   `json:"mobile"`
+}
+
+type BrandVersion struct {
+	Brand   string `json:"brand"`
+	Version string `json:"version"`
+}
+
+// Cookie is a browser cookie as the API returns it.
+type Cookie struct {
+	ID       string  `json:"id,omitempty"`
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"`
+	Secure   bool    `json:"secure"`
+	HTTPOnly bool    `json:"http_only"`
+	// HostOnly is true when the cookie applies to its exact host only and
+	// false for domain cookies that also match subdomains.
+	HostOnly bool   `json:"host_only"`
+	SameSite string `json:"same_site,omitempty"`
+	// Encoding is "base64" when Value was encoded by encode_binary_values.
+	Encoding string `json:"encoding,omitempty"`
+	// Truncated is set when max_value_len cut Value, whose full length
+	// in bytes OriginalLength then holds.
+	Truncated      bool `json:"truncated,omitempty"`
+	OriginalLength int  `json:"original_length,omitempty"`
+	// AuthScore, from 0 to 1, is how likely auth_only judged the cookie
+	// to carry authentication.
+	AuthScore float64 `json:"auth_score,omitempty"`
+}
+
+// Envelope wraps a cookie list with metadata about the fetch. It is only
+// returned when the client asks for it; the bare array stays the default.
+// Metadata is added here rather than to the array so existing clients
+// never see a shape change.
+type Envelope struct {
+	Version int    `json:"version"`
+	URL     string `json:"url"`
+	Count   int    `json:"count"`
+	// Total is the size of the whole cookie set when Cookies is one page
+	// of it.
+	Total   int      `json:"total,omitempty"`
+	Cookies []Cookie `json:"cookies"`
+	// CookieHeader joins the same cookies as Cookies into a Cookie header.
+	CookieHeader string    `json:"cookie_header,omitempty"`
+	Page         *PageInfo `json:"page,omitempty"`
+	// MatchedBy is the wait condition that was met: pattern or selector.
+	MatchedBy string `json:"matched_by,omitempty"`
+	// Globals holds the extract_globals values keyed by path.
+	Globals map[string]json.RawMessage `json:"globals,omitempty"`
+	// RedisKey is where the cookies were exported to server.redis_url.
+	RedisKey string `json:"redis_key,omitempty"`
+	// IndexedDB lists the origin's databases for include_indexeddb.
+	IndexedDB *IndexedDBInfo `json:"indexeddb,omitempty"`
+	// HTML is the rendered DOM for include_html, HTMLTruncated set when it
+	// was cut to html_max_bytes.
+	HTML          string `json:"html,omitempty"`
+	HTMLTruncated bool   `json:"html_truncated,omitempty"`
+	// Attempts is how many navigations retry_if_empty made.
+	Attempts int `json:"attempts,omitempty"`
+	// Partial marks cookies collected by best_effort after a wait timed
+	// out. Warning describes that, and a profile dir that doesn't exist.
+	Partial bool   `json:"partial,omitempty"`
+	Warning string `json:"warning,omitempty"`
+	// Params are the effective settings of the fetch, for echo_params.
+	Params *EffectiveParams `json:"params,omitempty"`
+}
+
+// PageInfo labels a fetch result with what the loaded page says about itself.
+type PageInfo struct {
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	CanonicalURL string `json:"canonical_url,omitempty"`
+}
+
+// IndexedDBInfo lists the IndexedDB databases of the page's origin, for
+// tracking down tokens that PWAs keep outside of cookies.
+type IndexedDBInfo struct {
+	Origin string `json:"origin"`
+	// UsageBytes is what the origin's IndexedDB takes up on disk.
+	UsageBytes float64             `json:"usage_bytes"`
+	Databases  []IndexedDBDatabase `json:"databases"`
+}
+
+type IndexedDBDatabase struct {
+	Name         string           `json:"name"`
+	Version      float64          `json:"version"`
+	ObjectStores []IndexedDBStore `json:"object_stores"`
+}
+
+type IndexedDBStore struct {
+	Name    string  `json:"name"`
+	Entries float64 `json:"entries"`
+}
+
+// EffectiveParams is what a fetch actually ran with, once the defaults, the
+// server config and the request's own options were combined. echo_params
+// adds it to the envelope as params.
+type EffectiveParams struct {
+	// TimeoutMS is the fetch's overall timeout and PatternTimeoutMS that
+	// of the pattern or selector wait. With a timeout_ms budget the waits
+	// get their share as they start, so BudgetMS is set instead of the
+	// latter.
+	TimeoutMS        int64 `json:"timeout_ms"`
+	PatternTimeoutMS int64 `json:"pattern_timeout_ms,omitempty"`
+	BudgetMS         int   `json:"budget_ms,omitempty"`
+	Headless         bool  `json:"headless"`
+	Interactive      bool  `json:"interactive,omitempty"`
+	Remote           bool  `json:"remote,omitempty"`
+	// Profile is the chrome.profiles name, not its dir, which only
+	// GET /profiles reveals.
+	Profile      string `json:"profile"`
+	CopyProfile  bool   `json:"copy_profile,omitempty"`
+	NewContext   bool   `json:"new_context,omitempty"`
+	Pattern      string `json:"pattern,omitempty"`
+	WaitSelector string `json:"wait_selector,omitempty"`
+	// Wait is the final wait: network_idle, lifecycle or none.
+	Wait                string `json:"wait"`
+	LifecycleEvent      string `json:"lifecycle_event,omitempty"`
+	NetworkIdleInflight int    `json:"network_idle_inflight,omitempty"`
+	BestEffort          bool   `json:"best_effort,omitempty"`
+	WarmupURL           string `json:"warmup_url,omitempty"`
+	NavMethod           string `json:"nav_method"`
+	// NavBody is redacted, since it typically carries login credentials,
+	// and seed cookies are only counted.
+	NavBody     string `json:"nav_body,omitempty"`
+	SeedCookies int    `json:"seed_cookies,omitempty"`
+	// Format is the response format, always the envelope that carries
+	// these params.
+	Format string `json:"format"`
+}
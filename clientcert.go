@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// clientCertTimeout bounds each request made on Chrome's behalf.
+const clientCertTimeout = 30 * time.Second
+
+// clientCertErrors are the navigation errors Chrome reports when a server
+// asks for a client certificate it doesn't have.
+var clientCertErrors = []string{
+	"net::ERR_SSL_CLIENT_AUTH_CERT_NEEDED",
+	"net::ERR_BAD_SSL_CLIENT_AUTH_CERT",
+}
+
+// clientCertFetcher performs the requests to mTLS hosts itself, presenting
+// chrome.client_cert, and hands the responses to Chrome through the Fetch
+// domain. Chrome can only pick client certs from the OS store, which a
+// headless server usually can't populate, so this is what makes a PEM
+// cert usable.
+type clientCertFetcher struct {
+	client *http.Client
+	hosts  map[string]bool
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// newClientCertFetcher returns nil when no client cert is configured. Without
+// chrome.client_cert_hosts the cert is only presented to target's host.
+func newClientCertFetcher(config Config, target string) (*clientCertFetcher, error) {
+	if config.Chrome.ClientCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.Chrome.ClientCert, config.Chrome.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chrome.client_cert: %v", err)
+	}
+
+	hosts := make(map[string]bool)
+	for _, h := range config.Chrome.ClientCertHosts {
+		hosts[strings.ToLower(h)] = true
+	}
+	if len(hosts) == 0 {
+		if u, err := url.Parse(target); err == nil {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if proxy := config.Chrome.Proxy; proxy.Server != "" {
+		proxyURL, err := url.Parse(proxy.Server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chrome.proxy.server: %v", err)
+		}
+		if proxy.Username != "" {
+			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &clientCertFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   clientCertTimeout,
+			// Chrome must see each redirect to update the URL and cookies.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		hosts: hosts,
+	}, nil
+}
+
+func (f *clientCertFetcher) matches(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && f.hosts[strings.ToLower(u.Hostname())]
+}
+
+// fulfill answers a paused request with the response fetched over mTLS, or
+// fails it so the page sees a network error.
+func (f *clientCertFetcher) fulfill(ctx context.Context, ev *fetch.EventRequestPaused) {
+	action, err := f.do(ctx, ev)
+	if err != nil {
+		f.mu.Lock()
+		f.lastErr = err
+		f.mu.Unlock()
+		log.Printf("Client certificate request to %s failed: %v", ev.Request.URL, err)
+		action = fetch.FailRequest(ev.RequestID, network.ErrorReasonFailed)
+	}
+	runIntercepted(ctx, action)
+}
+
+func (f *clientCertFetcher) do(ctx context.Context, ev *fetch.EventRequestPaused) (chromedp.Action, error) {
+	var body bytes.Buffer
+	for _, entry := range ev.Request.PostDataEntries {
+		b, err := base64.StdEncoding.DecodeString(entry.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode request body: %v", err)
+		}
+		body.Write(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, ev.Request.Method, ev.Request.URL, &body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range ev.Request.Headers {
+		req.Header.Set(name, fmt.Sprint(value))
+	}
+	// Let the transport negotiate compression so it decodes the body; the
+	// fulfilled response is handed to Chrome uncompressed.
+	req.Header.Del("Accept-Encoding")
+	// Paused requests don't carry cookies yet; the network stack adds them
+	// later, which is skipped when we answer the request ourselves.
+	cookies, err := network.GetCookies().WithURLs([]string{ev.Request.URL}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookies: %v", err)
+	}
+	for _, c := range cookies {
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var headers []*fetch.HeaderEntry
+	for name, values := range resp.Header {
+		for _, v := range values {
+			headers = append(headers, &fetch.HeaderEntry{Name: name, Value: v})
+		}
+	}
+	return fetch.FulfillRequest(ev.RequestID, int64(resp.StatusCode)).
+		WithResponseHeaders(headers).
+		WithBody(base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (f *clientCertFetcher) failure() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
+
+// clientCertError explains a failed fetch that came down to client
+// certificates, and returns nil for any other failure.
+func clientCertError(err error, f *clientCertFetcher) error {
+	for _, s := range clientCertErrors {
+		if strings.Contains(err.Error(), s) {
+			return &codedError{
+				Code:   "CLIENT_CERT_REQUIRED",
+				Status: http.StatusBadGateway,
+				Err:    errors.New("the site requires a TLS client certificate; configure chrome.client_cert and chrome.client_key, and chrome.client_cert_hosts if it isn't the target host"),
+			}
+		}
+	}
+	if f != nil {
+		if certErr := f.failure(); certErr != nil {
+			return &codedError{
+				Code:   "CLIENT_CERT_FAILED",
+				Status: http.StatusBadGateway,
+				Err:    fmt.Errorf("request with client certificate failed: %v", certErr),
+			}
+		}
+	}
+	return nil
+}
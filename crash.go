@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/chromedp"
+)
+
+// crashErrors are fragments of the errors chromedp surfaces when the page
+// or the browser dies under it, rather than the page failing to load.
+var crashErrors = []string{
+	"target crashed",
+	"websocket: close",
+	"use of closed network connection",
+	"unexpected EOF",
+}
+
+func chromeCrashed(err error) error {
+	return &codedError{
+		Code:   "CHROME_CRASHED",
+		Status: http.StatusBadGateway,
+		Err:    fmt.Errorf("Chrome crashed or disconnected during the fetch: %v", err),
+	}
+}
+
+// watchForCrash aborts the fetch as soon as ctx's target reports a renderer
+// crash, instead of leaving it to hang until the timeout.
+func watchForCrash(ctx context.Context, abort context.CancelCauseFunc) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			abort(chromeCrashed(errors.New("target crashed")))
+		}
+	})
+}
+
+// isCrashError reports whether a failed run looks like a browser crash or a
+// dropped DevTools connection.
+func isCrashError(err error) bool {
+	for _, s := range crashErrors {
+		if strings.Contains(err.Error(), s) {
+			return true
+		}
+	}
+	return false
+}
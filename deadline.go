@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// withRequestTimeout bounds every request to server.request_timeout as a
+// safety net on top of the fetch timeouts. A request still running at the
+// deadline gets a 504 and its context is cancelled, which stops its fetch;
+// whatever the handler writes afterwards is dropped. Unlike http.TimeoutHandler the response is
+// not buffered, so streamed responses keep working, but one that has
+// started streaming can only be cut off, not turned into a 504.
+func withRequestTimeout(next http.Handler, store *configStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := store.Load().Server.RequestTimeout
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, h: make(http.Header)}
+		done := make(chan struct{})
+		panics := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case p := <-panics:
+			panic(p)
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return
+			}
+			log.Printf("%s %s exceeded server.request_timeout of %v", r.Method, r.URL.Path, timeout)
+			if !tw.wroteHeader {
+				w.Header().Set("X-Error-Code", "REQUEST_TIMEOUT")
+				sendError(w, fmt.Sprintf("Request exceeded the server's %v timeout", timeout), http.StatusGatewayTimeout)
+			}
+		}
+	})
+}
+
+// timeoutWriter passes a handler's response through until the request times
+// out. The handler gets a header map of its own so it never touches the
+// real one concurrently with the 504.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	h           http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && !tw.wroteHeader {
+		tw.writeHeaderLocked(status)
+	}
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	for k, v := range tw.h {
+		tw.w.Header()[k] = v
+	}
+	tw.wroteHeader = true
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// fetchGroup collapses identical concurrent fetches into one browser run.
+var fetchGroup singleflight.Group
+
+// sharedFetches tracks the callers waiting on each in-flight shared fetch.
+// The fetch runs under a context of its own, cancelled once every caller
+// has gone, so one client disconnecting or timing out doesn't fail the
+// others.
+var sharedFetches = struct {
+	sync.Mutex
+	m map[string]*sharedFetch
+}{m: make(map[string]*sharedFetch)}
+
+type sharedFetch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// joinSharedFetch registers a caller for key and returns the context the
+// fetch runs under, and the func the caller must call once it stops
+// waiting.
+func joinSharedFetch(key string) (context.Context, func()) {
+	sharedFetches.Lock()
+	defer sharedFetches.Unlock()
+	f, ok := sharedFetches.m[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &sharedFetch{ctx: ctx, cancel: cancel}
+		sharedFetches.m[key] = f
+	}
+	f.waiters++
+	return f.ctx, func() {
+		sharedFetches.Lock()
+		defer sharedFetches.Unlock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+			if sharedFetches.m[key] == f {
+				delete(sharedFetches.m, key)
+				// A caller arriving now must not join the cancelled run.
+				fetchGroup.Forget(key)
+			}
+		}
+	}
+}
+
+// fetchCookiesShared is fetchCookies, except that a request identical to one
+// already in flight waits for and shares that fetch's result. Interactive
+// requests and those with no_dedup always get a fetch of their own.
+func fetchCookiesShared(ctx context.Context, payload RequestPayload, config Config) (*FetchResult, error) {
+	if payload.Interactive || payload.NoDedup {
+		return fetchCookies(ctx, payload, config, nil)
+	}
+	// Every option can change the result, so the key covers the whole
+	// payload rather than just the URL.
+	key, err := json.Marshal(payload)
+	if err != nil {
+		return fetchCookies(ctx, payload, config, nil)
+	}
+	groupKey := fmt.Sprintf("%t|%s", payload.schemeAdded, key)
+	fetchCtx, leave := joinSharedFetch(groupKey)
+	defer leave()
+	ch := fetchGroup.DoChan(groupKey, func() (interface{}, error) {
+		return fetchCookies(fetchCtx, payload, config, nil)
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Shared && verbose {
+		log.Printf("Shared an in-flight fetch of %s", payload.URL)
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	// Callers fill in cookie IDs in place, so each gets its own slice.
+	result := *res.Val.(*FetchResult)
+	result.Cookies = append([]Cookie(nil), result.Cookies...)
+	return &result, nil
+}
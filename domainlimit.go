@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// domainSlots enforces server.per_domain_concurrency. It's created once at
+// startup and nil when the option is unset.
+var domainSlots *domainLimiter
+
+// domainLimiter caps concurrent fetches per registrable domain, so
+// www.example.com and shop.example.com share one cap. It keeps a semaphore
+// per domain, dropped again once no fetch uses or waits for it.
+type domainLimiter struct {
+	limit int
+
+	mu   sync.Mutex
+	sems map[string]*domainSem
+}
+
+type domainSem struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newDomainLimiter(config Config) *domainLimiter {
+	if config.Server.PerDomainConcurrency <= 0 {
+		return nil
+	}
+	return &domainLimiter{
+		limit: config.Server.PerDomainConcurrency,
+		sems:  make(map[string]*domainSem),
+	}
+}
+
+// registrableDomain returns the eTLD+1 of the URL's host, or the host
+// itself for IPs and hosts without a public suffix such as localhost.
+func registrableDomain(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	host := strings.ToLower(u.Hostname())
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// acquire waits, in arrival order, for a slot of the URL's domain until ctx
+// is done. A nil limiter admits every fetch.
+func (l *domainLimiter) acquire(ctx context.Context, target string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	domain := registrableDomain(target)
+	l.mu.Lock()
+	sem, ok := l.sems[domain]
+	if !ok {
+		sem = &domainSem{slots: make(chan struct{}, l.limit)}
+		l.sems[domain] = sem
+	}
+	sem.refs++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if sem.refs--; sem.refs == 0 {
+			delete(l.sems, domain)
+		}
+	}
+	select {
+	case sem.slots <- struct{}{}:
+		return func() {
+			<-sem.slots
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		return nil, fmt.Errorf("timed out waiting for one of the %d fetch slots for %s", l.limit, domain)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// downloadGuard fails the fetch when the target answers with a file Chrome
+// downloads instead of a page it renders, e.g. a PDF or a binary. The
+// navigation of such a response never commits, so without it the fetch
+// would hang until its timeout.
+type downloadGuard struct {
+	abort context.CancelCauseFunc
+
+	mu sync.Mutex
+	// mimeType is that of the main frame's latest document response.
+	mimeType string
+	err      error
+}
+
+func newDownloadGuard(abort context.CancelCauseFunc) *downloadGuard {
+	return &downloadGuard{abort: abort}
+}
+
+// listen watches ctx's target for the main frame starting a download.
+func (g *downloadGuard) listen(ctx context.Context) {
+	mainFrame := cdp.FrameID(chromedp.FromContext(ctx).Target.TargetID)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		switch e := ev.(type) {
+		case *network.EventResponseReceived:
+			if e.Type == network.ResourceTypeDocument && e.FrameID == mainFrame {
+				g.mimeType = e.Response.MimeType
+			}
+		case *browser.EventDownloadWillBegin:
+			if e.FrameID != mainFrame || g.err != nil {
+				return
+			}
+			mimeType := g.mimeType
+			if mimeType == "" {
+				mimeType = "unknown"
+			}
+			g.err = &codedError{
+				Code:   "NOT_A_PAGE",
+				Status: http.StatusUnprocessableEntity,
+				Err:    fmt.Errorf("%s is a download (content type %s), not a page", e.URL, mimeType),
+			}
+			g.abort(g.err)
+		}
+	})
+}
+
+// deny makes Chrome refuse downloads, reporting them as events to listen
+// instead of writing them to disk. The Network domain supplies the content
+// type.
+func (g *downloadGuard) deny() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := enableNetwork(ctx); err != nil {
+			return err
+		}
+		err := browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorDeny).WithEventsEnabled(true).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to deny downloads: %v", err)
+		}
+		return nil
+	})
+}
+
+// failure is the NOT_A_PAGE error once a download began. The navigation
+// itself may fail first, with a less telling net::ERR_ABORTED.
+func (g *downloadGuard) failure() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+// redacted replaces the values echo_params must not return.
+const redacted = "[redacted]"
+
+// effectiveParams records the resolved settings of a fetch of payload.
+// budget is nil unless the request set timeout_ms.
+func effectiveParams(payload RequestPayload, config Config, headless bool, timeout, patternWait time.Duration, budget *stageBudget) *EffectiveParams {
+	params := &EffectiveParams{
+		TimeoutMS:           timeout.Milliseconds(),
+		Headless:            headless,
+		Interactive:         payload.Interactive,
+		Remote:              config.Chrome.RemoteWSURL != "",
+		Profile:             payload.Profile,
+		CopyProfile:         config.Chrome.CopyProfile,
+		NewContext:          payload.NewContext,
+		Pattern:             payload.Pattern,
+		WaitSelector:        payload.WaitSelector,
+		LifecycleEvent:      payload.LifecycleEvent,
+		NetworkIdleInflight: payload.NetworkIdleInflight,
+		BestEffort:          payload.BestEffort,
+		WarmupURL:           payload.WarmupURL,
+		NavMethod:           payload.NavMethod,
+		SeedCookies:         len(payload.SeedCookies),
+		Format:              "envelope",
+	}
+	if budget != nil {
+		params.BudgetMS = payload.TimeoutMS
+	} else if payload.Pattern != "" || payload.WaitSelector != "" {
+		params.PatternTimeoutMS = patternWait.Milliseconds()
+	}
+	switch {
+	case payload.LifecycleEvent != "":
+		params.Wait = "lifecycle"
+	case payload.SkipNetworkIdle:
+		params.Wait = "none"
+	default:
+		params.Wait = "network_idle"
+	}
+	if params.Profile == "" {
+		params.Profile = defaultProfileName
+	}
+	if params.NavMethod == "" {
+		params.NavMethod = "GET"
+	}
+	if payload.NavBody != "" {
+		params.NavBody = redacted
+	}
+	return params
+}
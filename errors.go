@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// codedError is a fetch failure with a stable, machine-readable code. The
+// code is returned to clients in the X-Error-Code header alongside the usual
+// plain-text message, so existing clients keep working unchanged.
+type codedError struct {
+	Code   string
+	Status int
+	Err    error
+	// RetryAfter, in seconds, is sent as Retry-After when set.
+	RetryAfter int
+}
+
+func (e *codedError) Error() string { return e.Err.Error() }
+
+func (e *codedError) Unwrap() error { return e.Err }
+
+// errorCode returns the code of the first codedError in err's chain, or "".
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return ""
+}
+
+// sendFetchError reports a failed fetchCookies call to the client.
+func sendFetchError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ce *codedError
+	if errors.As(err, &ce) {
+		w.Header().Set("X-Error-Code", ce.Code)
+		if ce.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(ce.RetryAfter))
+		}
+		if ce.Status != 0 {
+			status = ce.Status
+		}
+	}
+	sendError(w, fmt.Sprintf("Failed to fetch cookies: %v", err), status)
+}
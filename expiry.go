@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseExpiryOverride parses expiry_override into an absolute time: either
+// an RFC 3339 timestamp, or a positive duration from now such as 720h or
+// 30d.
+func parseExpiryOverride(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry_override %q", v)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry_override %q: expected an RFC 3339 time or a duration such as 720h or 30d", v)
+		}
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("expiry_override must be in the future")
+	}
+	return now.Add(d), nil
+}
+
+// overrideExpiries rewrites the exported expiries for ?expiry_override:
+// session cookies and cookies expiring later than the override get the
+// override as their expiry, earlier ones keep theirs. The browser's cookies
+// are left alone.
+func overrideExpiries(r *http.Request, cookies []Cookie) {
+	v := r.URL.Query().Get("expiry_override")
+	if v == "" {
+		return
+	}
+	at, err := parseExpiryOverride(v, time.Now())
+	if err != nil {
+		return
+	}
+	expires := float64(at.Unix())
+	for i := range cookies {
+		if cookies[i].Expires <= 0 || cookies[i].Expires > expires {
+			cookies[i].Expires = expires
+		}
+	}
+}
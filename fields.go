@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cookieFields are the JSON names of the Cookie fields, in the order they
+// are serialized, that ?fields can select.
+var cookieFields = []string{
+	"id", "name", "value", "domain", "path", "expires",
+	"secure", "http_only", "host_only", "same_site", "encoding",
+	"truncated", "original_length", "auth_score",
+}
+
+// parseFields parses a comma-separated list of cookieFields.
+func parseFields(s string) ([]string, error) {
+	known := make(map[string]bool, len(cookieFields))
+	for _, f := range cookieFields {
+		known[f] = true
+	}
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !known[f] {
+			return nil, fmt.Errorf("invalid fields entry %q: expected a comma-separated list of %s", f, strings.Join(cookieFields, ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// requestedFields returns the ?fields of r, which validateOutput has
+// already checked, or nil when absent.
+func requestedFields(r *http.Request) []string {
+	s := r.URL.Query().Get("fields")
+	if s == "" {
+		return nil
+	}
+	fields, _ := parseFields(s)
+	return fields
+}
+
+// projectCookies serializes each cookie with only the given fields, in
+// Cookie's own field order. Fields a cookie omits when empty, such as
+// same_site, stay omitted.
+func projectCookies(cookies []Cookie, fields []string) ([]json.RawMessage, error) {
+	selected := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		selected[f] = true
+	}
+	projected := make([]json.RawMessage, 0, len(cookies))
+	for _, c := range cookies {
+		full, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(full, &values); err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		b.WriteByte('{')
+		for _, f := range cookieFields {
+			v, ok := values[f]
+			if !ok || !selected[f] {
+				continue
+			}
+			if b.Len() > 1 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%q:%s", f, v)
+		}
+		b.WriteByte('}')
+		projected = append(projected, json.RawMessage(b.String()))
+	}
+	return projected, nil
+}
+
+// projectedEnvelope replaces the cookies of an envelope with their
+// projection; the outer Cookies field shadows the embedded one.
+type projectedEnvelope struct {
+	Envelope
+	Cookies []json.RawMessage `json:"cookies"`
+}
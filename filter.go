@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// cookieFilter reports whether a cookie should be kept.
+type cookieFilter func(Cookie) bool
+
+// cookieFilters compiles the request's cookie filters. A cookie is returned
+// only if it passes all of them, so combining filters narrows the result.
+// With case_insensitive the name and value filters ignore case, although
+// cookie names are case-sensitive.
+func cookieFilters(payload RequestPayload) ([]cookieFilter, error) {
+	var filters []cookieFilter
+	fold, reFlags := func(s string) string { return s }, ""
+	if payload.CaseInsensitive {
+		fold, reFlags = strings.ToLower, "(?i)"
+	}
+	if payload.NamePattern != "" {
+		re, err := regexp.Compile(reFlags + payload.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name_pattern: %v", err)
+		}
+		filters = append(filters, func(c Cookie) bool { return re.MatchString(c.Name) })
+	}
+	if prefix := fold(payload.NamePrefix); prefix != "" {
+		filters = append(filters, func(c Cookie) bool { return strings.HasPrefix(fold(c.Name), prefix) })
+	}
+	if substr := fold(payload.NameContains); substr != "" {
+		filters = append(filters, func(c Cookie) bool { return strings.Contains(fold(c.Name), substr) })
+	}
+	if payload.ValuePattern != "" {
+		re, err := regexp.Compile(reFlags + payload.ValuePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value_pattern: %v", err)
+		}
+		filters = append(filters, func(c Cookie) bool { return re.MatchString(c.Value) })
+	}
+	if path := payload.Path; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid path %q: must start with /", path)
+		}
+		filters = append(filters, func(c Cookie) bool { return pathMatches(path, c.Path) })
+	}
+	if payload.OnlyPersistent && payload.OnlySession {
+		return nil, fmt.Errorf("only_persistent and only_session are mutually exclusive")
+	}
+	// Session cookies are reported with an expiry of -1.
+	if payload.OnlyPersistent {
+		filters = append(filters, func(c Cookie) bool { return c.Expires > 0 })
+	}
+	if payload.OnlySession {
+		filters = append(filters, func(c Cookie) bool { return c.Expires <= 0 })
+	}
+	return filters, nil
+}
+
+// pathMatches implements the path-match of RFC 6265 section 5.1.4: a cookie
+// applies to a request path equal to its own, or below it on a "/" boundary,
+// so /docs matches /docs/guide but not /docsearch.
+func pathMatches(requestPath, cookiePath string) bool {
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return len(requestPath) == len(cookiePath) ||
+		strings.HasSuffix(cookiePath, "/") ||
+		requestPath[len(cookiePath)] == '/'
+}
+
+func applyFilters(cookies []Cookie, filters []cookieFilter) []Cookie {
+	if len(filters) == 0 {
+		return cookies
+	}
+	var kept []Cookie
+outer:
+	for _, c := range cookies {
+		for _, keep := range filters {
+			if !keep(c) {
+				continue outer
+			}
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// stripCookies drops cookies whose name matches any of the given regex
+// patterns. It backs the server-wide strip_cookies denylist.
+func stripCookies(cookies []Cookie, patterns []string) ([]Cookie, error) {
+	if len(patterns) == 0 {
+		return cookies, nil
+	}
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strip_cookies pattern %q: %v", p, err)
+		}
+		regexes = append(regexes, re)
+	}
+
+	var kept []Cookie
+	for _, c := range cookies {
+		if !matchesAny(regexes, c.Name) {
+			kept = append(kept, c)
+		}
+	}
+	if verbose {
+		log.Printf("Stripped %d cookies matching strip_cookies", len(cookies)-len(kept))
+	}
+	return kept, nil
+}
+
+func matchesAny(regexes []*regexp.Regexp, s string) bool {
+	for _, re := range regexes {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
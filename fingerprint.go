@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+)
+
+func chromeBrands(version string) []BrandVersion {
+	return []BrandVersion{
+		{Brand: "Chromium", Version: version},
+		{Brand: "Google Chrome", Version: version},
+		{Brand: "Not=A?Brand", Version: "24"},
+	}
+}
+
+// fingerprintPresets are consistent profiles of common browsers. The
+// engine is still Chrome's, so a Safari preset only changes what the page
+// is told, not how it renders.
+var fingerprintPresets = map[string]FingerprintOptions{
+	"windows-chrome": {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+		Platform:  "Win32",
+		Languages: []string{"en-US", "en"},
+		ClientHints: &ClientHints{
+			Brands:          chromeBrands("140"),
+			FullVersionList: chromeBrands("140.0.0.0"),
+			Platform:        "Windows",
+			PlatformVersion: "15.0.0",
+			Architecture:    "x86",
+			Bitness:         "64",
+		},
+	},
+	"macos-chrome": {
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+		Platform:  "MacIntel",
+		Languages: []string{"en-US", "en"},
+		ClientHints: &ClientHints{
+			Brands:          chromeBrands("140"),
+			FullVersionList: chromeBrands("140.0.0.0"),
+			Platform:        "macOS",
+			PlatformVersion: "15.0.0",
+			Architecture:    "arm",
+			Bitness:         "64",
+		},
+	},
+	"macos-safari": {
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Safari/605.1.15",
+		Platform:  "MacIntel",
+		Languages: []string{"en-US"},
+	},
+	"android-chrome": {
+		UserAgent: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Mobile Safari/537.36",
+		Platform:  "Linux armv81",
+		Languages: []string{"en-US", "en"},
+		ClientHints: &ClientHints{
+			Brands:          chromeBrands("140"),
+			FullVersionList: chromeBrands("140.0.0.0"),
+			Platform:        "Android",
+			PlatformVersion: "14.0.0",
+			Mobile:          true,
+		},
+	},
+}
+
+func fingerprintPresetNames() string {
+	names := make([]string, 0, len(fingerprintPresets))
+	for name := range fingerprintPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+var languageTagRe = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// resolveFingerprint returns the preset, if any, overlaid with the given fields.
+func resolveFingerprint(o FingerprintOptions) (FingerprintOptions, error) {
+	resolved := FingerprintOptions{}
+	if o.Preset != "" {
+		preset, ok := fingerprintPresets[o.Preset]
+		if !ok {
+			return resolved, fmt.Errorf("unknown fingerprint preset %q: expected one of %s", o.Preset, fingerprintPresetNames())
+		}
+		resolved = preset
+	}
+	if o.UserAgent != "" {
+		resolved.UserAgent = o.UserAgent
+	}
+	if o.Platform != "" {
+		resolved.Platform = o.Platform
+	}
+	if o.Languages != nil {
+		resolved.Languages = o.Languages
+	}
+	if o.ClientHints != nil {
+		resolved.ClientHints = o.ClientHints
+	}
+	return resolved, nil
+}
+
+func validateFingerprint(o FingerprintOptions) error {
+	resolved, err := resolveFingerprint(o)
+	if err != nil {
+		return err
+	}
+	if resolved.UserAgent == "" {
+		return fmt.Errorf("fingerprint requires a preset or a user_agent")
+	}
+	for _, lang := range resolved.Languages {
+		if !languageTagRe.MatchString(lang) {
+			return fmt.Errorf("invalid fingerprint language %q: expected a language tag such as de-DE", lang)
+		}
+	}
+	return nil
+}
+
+// languagesScript pins navigator.languages, and navigator.language, to the
+// fingerprint's list in every document before its own scripts run.
+const languagesScript = `(() => {
+	const languages = Object.freeze(%s);
+	Object.defineProperty(Navigator.prototype, "languages", {get: () => languages});
+	Object.defineProperty(Navigator.prototype, "language", {get: () => languages[0]});
+})()`
+
+// applyFingerprint overrides the user agent, platform, client hints and
+// languages of the fetch's browser before it navigates.
+func applyFingerprint(ctx context.Context, opts FingerprintOptions) error {
+	fp, err := resolveFingerprint(opts)
+	if err != nil {
+		return err
+	}
+	override := emulation.SetUserAgentOverride(fp.UserAgent).WithPlatform(fp.Platform)
+	if len(fp.Languages) > 0 {
+		override = override.WithAcceptLanguage(strings.Join(fp.Languages, ","))
+	}
+	if ch := fp.ClientHints; ch != nil {
+		override = override.WithUserAgentMetadata(&emulation.UserAgentMetadata{
+			Brands:          brandVersions(ch.Brands),
+			FullVersionList: brandVersions(ch.FullVersionList),
+			Platform:        ch.Platform,
+			PlatformVersion: ch.PlatformVersion,
+			Architecture:    ch.Architecture,
+			Bitness:         ch.Bitness,
+			Model:           ch.Model,
+			Mobile:          ch.Mobile,
+		})
+	}
+	if err := override.Do(ctx); err != nil {
+		return fmt.Errorf("failed to override the user agent: %v", err)
+	}
+	if len(fp.Languages) > 0 {
+		languages, err := json.Marshal(fp.Languages)
+		if err != nil {
+			return err
+		}
+		if _, err := page.AddScriptToEvaluateOnNewDocument(fmt.Sprintf(languagesScript, languages)).Do(ctx); err != nil {
+			return fmt.Errorf("failed to override navigator.languages: %v", err)
+		}
+	}
+	return nil
+}
+
+func brandVersions(brands []BrandVersion) []*emulation.UserAgentBrandVersion {
+	out := make([]*emulation.UserAgentBrandVersion, 0, len(brands))
+	for _, b := range brands {
+		out = append(out, &emulation.UserAgentBrandVersion{Brand: b.Brand, Version: b.Version})
+	}
+	return out
+}
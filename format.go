@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// Format is the shape of a fetch response, as chosen by negotiateFormat and
+// written by writeResponse.
+type Format int
+
+const (
+	FormatJSON Format = iota
+	FormatEnvelope
+	FormatSummary
+	FormatNetscape
+	FormatHeader
+	FormatStorageState
+	FormatJSONDownload
+	FormatCSV
+	// FormatRaw is the cookies exactly as CDP reports them.
+	FormatRaw
+)
+
+// formatNames are the values accepted by ?format= and server.default_format.
+// The envelope and the summary are JSON variants selected by their own
+// parameters instead.
+var formatNames = map[string]Format{
+	"json":          FormatJSON,
+	"netscape":      FormatNetscape,
+	"header":        FormatHeader,
+	"storagestate":  FormatStorageState,
+	"json-download": FormatJSONDownload,
+	"csv":           FormatCSV,
+}
+
+// formatMediaTypes map Accept media types to the format they select. Formats
+// without a distinctive media type can only be picked with ?format=.
+var formatMediaTypes = map[string]Format{
+	"application/json":  FormatJSON,
+	envelopeMediaType:   FormatEnvelope,
+	"text/csv":          FormatCSV,
+	"application/x-csv": FormatCSV,
+}
+
+// negotiateFormat picks the response format. In order of precedence:
+// ?format=, then summary=true, envelope=true, include_header=true,
+// echo_params=true, raw=true or fields, then the Accept header (or
+// server.default_accept when the request sends none or only */*), then
+// server.default_format, and finally bare JSON.
+func negotiateFormat(r *http.Request, config Config) (Format, error) {
+	summary := queryBool(r, "summary")
+	envelope := queryBool(r, "envelope") || queryBool(r, "include_header") || queryBool(r, "echo_params")
+	if name := r.URL.Query().Get("format"); name != "" {
+		f, ok := formatNames[name]
+		if !ok {
+			return FormatJSON, fmt.Errorf("unsupported format %q", name)
+		}
+		if f == FormatJSON {
+			return jsonVariant(summary, envelope, r), nil
+		}
+		return f, nil
+	}
+	if summary || envelope || queryBool(r, "raw") || r.URL.Query().Get("fields") != "" {
+		return jsonVariant(summary, envelope, r), nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" || strings.TrimSpace(accept) == "*/*" {
+		accept = config.Server.DefaultAccept
+	}
+	if f, ok := formatFromAccept(accept); ok {
+		return f, nil
+	}
+	if f, ok := formatNames[config.Server.DefaultFormat]; ok {
+		return f, nil
+	}
+	return FormatJSON, nil
+}
+
+func jsonVariant(summary, envelope bool, r *http.Request) Format {
+	switch {
+	case summary:
+		return FormatSummary
+	case envelope || strings.Contains(r.Header.Get("Accept"), envelopeMediaType):
+		return FormatEnvelope
+	case queryBool(r, "raw"):
+		return FormatRaw
+	}
+	return FormatJSON
+}
+
+// formatFromAccept returns the format of the most preferred media type in an
+// Accept header that maps to one. Wildcards never select a format.
+func formatFromAccept(accept string) (Format, bool) {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mr := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, param := range fields[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		if mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, mr := range ranges {
+		if f, ok := formatMediaTypes[mr.mediaType]; ok {
+			return f, true
+		}
+	}
+	return FormatJSON, false
+}
+
+// writeResponse writes the fetched cookies in format f.
+func writeResponse(w http.ResponseWriter, r *http.Request, f Format, url string, result *FetchResult) {
+	cookies := result.Cookies
+	switch f {
+	case FormatSummary:
+		sendJSONResponse(w, summarizeCookies(url, cookies))
+	case FormatEnvelope:
+		if strings.Contains(r.Header.Get("Accept"), envelopeMediaType) {
+			w.Header().Set("Content-Type", envelopeMediaType)
+		}
+		env := newEnvelope(r, url, result)
+		if fields := requestedFields(r); fields != nil {
+			projected, err := projectCookies(env.Cookies, fields)
+			if err != nil {
+				sendError(w, "Failed to encode response", http.StatusInternalServerError)
+				return
+			}
+			sendJSONResponse(w, projectedEnvelope{Envelope: env, Cookies: projected})
+			return
+		}
+		sendJSONResponse(w, env)
+	case FormatNetscape:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeNetscape(w, cookies); err != nil {
+			log.Printf("Failed to write Netscape cookies: %v", err)
+		}
+	case FormatHeader:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, cookieHeader(cookies))
+	case FormatStorageState:
+		sendJSONResponse(w, newStorageState(cookies))
+	case FormatJSONDownload:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", downloadFilename(url)))
+		sendJSONResponse(w, newExtensionCookies(cookies))
+	case FormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if err := writeCSV(w, cookies); err != nil {
+			log.Printf("Failed to write CSV cookies: %v", err)
+		}
+	case FormatRaw:
+		raw := result.Raw
+		if raw == nil {
+			raw = []*network.Cookie{}
+		}
+		sendJSONResponse(w, raw)
+	default:
+		if fields := requestedFields(r); fields != nil {
+			projected, err := projectCookies(cookies, fields)
+			if err != nil {
+				sendError(w, "Failed to encode response", http.StatusInternalServerError)
+				return
+			}
+			sendJSONResponse(w, projected)
+			return
+		}
+		sendJSONResponse(w, cookies)
+	}
+}
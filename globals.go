@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// maxExtractGlobals bounds extract_globals.
+const maxExtractGlobals = 20
+
+// globalPathRe accepts dotted property paths such as window.__CONFIG__ or
+// dataLayer. Anything else is rejected so no client-supplied code runs.
+var globalPathRe = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+func validateExtractGlobals(paths []string) error {
+	if len(paths) > maxExtractGlobals {
+		return fmt.Errorf("extract_globals allows at most %d paths", maxExtractGlobals)
+	}
+	for _, p := range paths {
+		if !globalPathRe.MatchString(p) {
+			return fmt.Errorf("invalid extract_globals path %q: expected a dotted property path like window.__CONFIG__", p)
+		}
+	}
+	return nil
+}
+
+// globalScript walks a property path from window and serializes the value
+// to JSON in the page, so functions, DOM nodes and cycles degrade gracefully
+// instead of failing the evaluation. Undefined values become null. Only an
+// object that contains itself is a cycle; ancestors holds the objects on
+// the path to the value being serialized, which is the replacer's this.
+const globalScript = `((path) => {
+	let v = window;
+	for (const key of path) {
+		if (v === null || v === undefined) return "null";
+		v = v[key];
+	}
+	const ancestors = [];
+	try {
+		const out = JSON.stringify(v, function (k, val) {
+			if (typeof val === "function") return undefined;
+			if (typeof val === "bigint") return val.toString();
+			if (typeof Node !== "undefined" && val instanceof Node) return undefined;
+			if (typeof val === "object" && val !== null) {
+				while (ancestors.length > 0 && ancestors[ancestors.length - 1] !== this) ancestors.pop();
+				if (ancestors.includes(val)) return "[Circular]";
+				ancestors.push(val);
+			}
+			return val;
+		});
+		return out === undefined ? "null" : out;
+	} catch (e) {
+		return JSON.stringify(String(v));
+	}
+})(%s)`
+
+// extractGlobals evaluates each path and returns the values keyed by path.
+// The page's JavaScript may override JSON.stringify, so a value that isn't
+// valid JSON becomes null, with a warning.
+func extractGlobals(ctx context.Context, paths []string) (values map[string]json.RawMessage, warnings []string, err error) {
+	values = make(map[string]json.RawMessage, len(paths))
+	for _, p := range paths {
+		keys := strings.Split(strings.TrimPrefix(p, "window."), ".")
+		if p == "window" {
+			keys = nil
+		}
+		arg, err := json.Marshal(keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		var out string
+		if err := chromedp.Evaluate(fmt.Sprintf(globalScript, arg), &out).Do(ctx); err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		if !json.Valid([]byte(out)) {
+			log.Printf("Global %s didn't serialize to valid JSON, returning null", p)
+			warnings = append(warnings, fmt.Sprintf("global %s didn't serialize to valid JSON", p))
+			out = "null"
+		}
+		values[p] = json.RawMessage(out)
+	}
+	return values, warnings, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+// gpuDefault picks the chrome.disable_gpu default at build time, e.g.
+// -ldflags "-X main.gpuDefault=off" for container images. "on" keeps the
+// GPU enabled, "off" disables it and "" detects a container at runtime.
+var gpuDefault = ""
+
+// disableGPUFlags turn off the GPU stack, which is absent in most
+// containers and otherwise crashes or floods the log there.
+var disableGPUFlags = []chromedp.ExecAllocatorOption{
+	chromedp.Flag("disable-gpu", true),
+	chromedp.Flag("disable-software-rasterizer", true),
+	chromedp.Flag("disable-gpu-compositing", true),
+}
+
+var (
+	containerOnce sync.Once
+	isContainer   bool
+)
+
+// detectContainer runs inContainer once; the answer can't change while the
+// process is running.
+func detectContainer() bool {
+	containerOnce.Do(func() {
+		isContainer = inContainer()
+		if verbose {
+			log.Printf("Container environment detected: %v", isContainer)
+		}
+	})
+	return isContainer
+}
+
+// shouldDisableGPU resolves chrome.disable_gpu, falling back to the build
+// time default and then to container detection.
+func shouldDisableGPU(config Config) bool {
+	if v := config.Chrome.DisableGPU; v != nil {
+		return *v
+	}
+	switch gpuDefault {
+	case "on":
+		return false
+	case "off":
+		return true
+	}
+	return detectContainer()
+}
+
+// inContainer guesses whether the process runs in a container from the
+// marker files Docker and Podman create, the Kubernetes service env and the
+// cgroup of PID 1.
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, s := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(string(cgroup), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// languageRangeRe matches one entry of an Accept-Language list: a BCP 47
+// style language range with an optional quality value.
+var languageRangeRe = regexp.MustCompile(`^(\*|[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*)(;q=(0(\.[0-9]{0,3})?|1(\.0{0,3})?))?$`)
+
+func validateAcceptLanguage(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if !languageRangeRe.MatchString(strings.TrimSpace(part)) {
+			return fmt.Errorf("invalid accept_language %q: %q is not a language tag", value, strings.TrimSpace(part))
+		}
+	}
+	return nil
+}
+
+// contentCodings are the Accept-Encoding codings Chrome can decode.
+var contentCodings = map[string]bool{
+	"gzip": true, "deflate": true, "br": true, "zstd": true, "identity": true, "*": true,
+}
+
+var qualityRe = regexp.MustCompile(`^q=(0(\.[0-9]{0,3})?|1(\.0{0,3})?)$`)
+
+// validateAcceptEncoding checks an Accept-Encoding list. Only codings
+// Chrome can decode are allowed, so a page never arrives undecodable.
+func validateAcceptEncoding(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		coding, q, hasQ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if !contentCodings[coding] {
+			return fmt.Errorf("invalid accept_encoding %q: unsupported coding %q", value, coding)
+		}
+		if hasQ && !qualityRe.MatchString(strings.TrimSpace(q)) {
+			return fmt.Errorf("invalid accept_encoding %q: bad quality value in %q", value, strings.TrimSpace(part))
+		}
+	}
+	return nil
+}
+
+func validateReferrer(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid referrer %q: expected an absolute http(s) URL", value)
+	}
+	return nil
+}
+
+// validateOrigin checks that value is a serialized origin as browsers send
+// it: an http(s) scheme and host with an optional port, and nothing else,
+// not even a trailing slash.
+func validateOrigin(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || u.Path != "" || u.RawQuery != "" || u.ForceQuery || strings.Contains(value, "#") {
+		return fmt.Errorf("invalid origin %q: expected a scheme and host such as https://app.example.com, without a path", value)
+	}
+	return nil
+}
+
+// localeFromAcceptLanguage turns the first, preferred language of an
+// Accept-Language value into the ICU locale Chrome's locale override
+// expects, e.g. "de-DE,de;q=0.9" becomes "de_DE".
+func localeFromAcceptLanguage(value string) string {
+	first := strings.TrimSpace(strings.SplitN(value, ",", 2)[0])
+	first = strings.SplitN(first, ";", 2)[0]
+	if first == "*" {
+		return ""
+	}
+	return strings.ReplaceAll(first, "-", "_")
+}
+
+// extraHeaders collects the request headers a payload asks Chrome to send
+// with every request of the fetch.
+func extraHeaders(payload RequestPayload) network.Headers {
+	headers := network.Headers{}
+	if payload.AcceptLanguage != "" {
+		headers["Accept-Language"] = payload.AcceptLanguage
+	}
+	if payload.Referrer != "" {
+		headers["Referer"] = payload.Referrer
+	}
+	if payload.AcceptEncoding != "" {
+		headers["Accept-Encoding"] = payload.AcceptEncoding
+	}
+	if payload.Origin != "" {
+		headers["Origin"] = payload.Origin
+	}
+	return headers
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// defaultHTMLMaxBytes is how much of the DOM include_html returns
+	// unless html_max_bytes says otherwise.
+	defaultHTMLMaxBytes = 1 << 20
+	// maxHTMLMaxBytes bounds html_max_bytes.
+	maxHTMLMaxBytes = 10 << 20
+)
+
+func validateHTMLMaxBytes(payload RequestPayload) error {
+	if payload.HTMLMaxBytes == 0 {
+		return nil
+	}
+	if !payload.IncludeHTML {
+		return fmt.Errorf("html_max_bytes requires include_html")
+	}
+	if payload.HTMLMaxBytes < 0 || payload.HTMLMaxBytes > maxHTMLMaxBytes {
+		return fmt.Errorf("html_max_bytes must be between 1 and %d", maxHTMLMaxBytes)
+	}
+	return nil
+}
+
+// captureHTML returns the rendered DOM of the page, cut to at most
+// maxBytes on a UTF-8 boundary, and whether it had to be cut.
+func captureHTML(ctx context.Context, maxBytes int) (string, bool, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultHTMLMaxBytes
+	}
+	var html string
+	if err := chromedp.OuterHTML("html", &html, chromedp.ByQuery).Do(ctx); err != nil {
+		return "", false, err
+	}
+	if len(html) <= maxBytes {
+		return html, false, nil
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(html[cut]) {
+		cut--
+	}
+	return html[:cut], true, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chromedp/cdproto/indexeddb"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// captureIndexedDB enumerates the databases and object stores of the current
+// page's origin, with entry counts but without their values.
+func captureIndexedDB(ctx context.Context) (*IndexedDBInfo, error) {
+	var origin string
+	if err := chromedp.Evaluate(`location.origin`, &origin).Do(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read page origin: %v", err)
+	}
+	info := &IndexedDBInfo{Origin: origin, Databases: []IndexedDBDatabase{}}
+	if origin == "" || origin == "null" {
+		return info, nil
+	}
+
+	if err := indexeddb.Enable().Do(ctx); err != nil {
+		return nil, err
+	}
+	names, err := indexeddb.RequestDatabaseNames().WithSecurityOrigin(origin).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
+	for _, name := range names {
+		db, err := indexeddb.RequestDatabase(name).WithSecurityOrigin(origin).Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database %s: %v", name, err)
+		}
+		database := IndexedDBDatabase{Name: db.Name, Version: db.Version, ObjectStores: []IndexedDBStore{}}
+		for _, store := range db.ObjectStores {
+			entries, _, err := indexeddb.GetMetadata(name, store.Name).WithSecurityOrigin(origin).Do(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read object store %s/%s: %v", name, store.Name, err)
+			}
+			database.ObjectStores = append(database.ObjectStores, IndexedDBStore{Name: store.Name, Entries: entries})
+		}
+		info.Databases = append(info.Databases, database)
+	}
+
+	_, _, _, usage, err := storage.GetUsageAndQuota(origin).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage usage: %v", err)
+	}
+	for _, u := range usage {
+		if u.StorageType == storage.TypeIndexeddb {
+			info.UsageBytes = u.Usage
+		}
+	}
+	return info, nil
+}
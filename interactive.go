@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// acceptsEventStream reports whether the client asked for Server-Sent Events.
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamFetchCookies runs fetchCookies and reports its progress as SSE
+// "progress" events, finishing with a single "cookies" or "error" event.
+// The cookies event carries an Envelope when the request asked for one, and
+// is followed by a "signature" event of its data when server.signing_key is
+// set.
+func streamFetchCookies(w http.ResponseWriter, r *http.Request, payload RequestPayload, config Config) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, "Streaming is not supported by this connection", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(event string, data interface{}) []byte {
+		body, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("Failed to encode %s event: %v", event, err)
+			return nil
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+		flusher.Flush()
+		return body
+	}
+
+	result, err := fetchCookies(r.Context(), payload, config, func(stage string) {
+		send("progress", map[string]string{"stage": stage})
+	})
+	if err != nil {
+		log.Printf("Error: Failed to fetch cookies: %v", err)
+		event := map[string]string{"error": fmt.Sprintf("Failed to fetch cookies: %v", err)}
+		if code := errorCode(err); code != "" {
+			event["code"] = code
+		}
+		send("error", event)
+		return
+	}
+	if verbose {
+		log.Printf("Streaming %d cookies for %s", len(result.Cookies), payload.URL)
+	}
+	processResult(r, payload.URL, result)
+	data, err := cookiesEvent(r, payload.URL, result)
+	if err != nil {
+		log.Printf("Failed to encode cookies event: %v", err)
+		send("error", map[string]string{"error": "Failed to encode response"})
+		return
+	}
+	body := send("cookies", data)
+	if body == nil || config.Server.SigningKey == "" {
+		return
+	}
+	sig, err := signBody(config, body)
+	if err != nil {
+		log.Printf("Failed to sign cookies event: %v", err)
+		send("error", map[string]string{"error": "Failed to sign response"})
+		return
+	}
+	send("signature", map[string]string{"signature": sig})
+}
+
+// cookiesEvent is the data of the final cookies event: the envelope when r
+// asks for one, the cookie array otherwise, either with only the requested
+// fields of each cookie.
+func cookiesEvent(r *http.Request, url string, result *FetchResult) (interface{}, error) {
+	fields := requestedFields(r)
+	if wantsEnvelope(r) {
+		env := newEnvelope(r, url, result)
+		if fields == nil {
+			return env, nil
+		}
+		projected, err := projectCookies(env.Cookies, fields)
+		if err != nil {
+			return nil, err
+		}
+		return projectedEnvelope{Envelope: env, Cookies: projected}, nil
+	}
+	cookies := result.Cookies
+	if cookies == nil {
+		cookies = []Cookie{}
+	}
+	if fields == nil {
+		return cookies, nil
+	}
+	return projectCookies(cookies, fields)
+}
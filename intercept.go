@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/chromedp"
+)
+
+// enableInterception turns on the Fetch domain for proxy authentication,
+// client certificates and POST navigation, whichever are needed. A target
+// has a single Fetch.enable, so one listener serves all of them: the armed
+// navigation is sent as a POST, requests to client cert hosts are fulfilled
+// by certs, all others are continued untouched.
+func enableInterception(ctx context.Context, auth *proxyAuth, certs *clientCertFetcher, post *navPost) error {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			if post.takes(ev) {
+				go runIntercepted(ctx, post.continueRequest(ev))
+				return
+			}
+			if certs != nil && certs.matches(ev.Request.URL) {
+				go certs.fulfill(ctx, ev)
+				return
+			}
+			go runIntercepted(ctx, fetch.ContinueRequest(ev.RequestID))
+		case *fetch.EventAuthRequired:
+			go runIntercepted(ctx, fetch.ContinueWithAuth(ev.RequestID, auth.respond(ev)))
+		}
+	})
+	if verbose {
+		if auth != nil {
+			log.Printf("Enabling proxy authentication for user %s", auth.username)
+		}
+		if certs != nil {
+			log.Printf("Presenting the client certificate to %d host(s)", len(certs.hosts))
+		}
+	}
+	return fetch.Enable().WithHandleAuthRequests(auth != nil).Do(ctx)
+}
+
+func runIntercepted(ctx context.Context, action chromedp.Action) {
+	if err := action.Do(ctx); err != nil && verbose {
+		log.Printf("Failed to answer intercepted request: %v", err)
+	}
+}
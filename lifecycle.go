@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// lifecycleEvents are the page lifecycle events lifecycle_event accepts,
+// named as Chrome reports them.
+var lifecycleEvents = map[string]bool{
+	"DOMContentLoaded":     true,
+	"load":                 true,
+	"networkAlmostIdle":    true,
+	"networkIdle":          true,
+	"firstMeaningfulPaint": true,
+}
+
+func validateLifecycleEvent(name string) error {
+	if !lifecycleEvents[name] {
+		return fmt.Errorf("invalid lifecycle_event %q: expected DOMContentLoaded, load, networkAlmostIdle, networkIdle or firstMeaningfulPaint", name)
+	}
+	return nil
+}
+
+// lifecycleWatcher records the lifecycle events of the main frame per
+// document, so a wait only counts events of the document currently shown and
+// not those of about:blank or a page that has since navigated away.
+type lifecycleWatcher struct {
+	mu     sync.Mutex
+	seen   map[cdp.LoaderID]map[string]bool
+	notify chan struct{}
+}
+
+// watchLifecycle enables lifecycle events on ctx's target and starts
+// recording them. It must run before navigating.
+func watchLifecycle(ctx context.Context) (*lifecycleWatcher, error) {
+	w := &lifecycleWatcher{
+		seen:   make(map[cdp.LoaderID]map[string]bool),
+		notify: make(chan struct{}, 1),
+	}
+	mainFrame := cdp.FrameID(chromedp.FromContext(ctx).Target.TargetID)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		e, ok := ev.(*page.EventLifecycleEvent)
+		if !ok || e.FrameID != mainFrame {
+			return
+		}
+		w.mu.Lock()
+		if w.seen[e.LoaderID] == nil {
+			w.seen[e.LoaderID] = make(map[string]bool)
+		}
+		w.seen[e.LoaderID][e.Name] = true
+		w.mu.Unlock()
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	})
+	if err := page.SetLifecycleEventsEnabled(true).Do(ctx); err != nil {
+		return nil, fmt.Errorf("failed to enable lifecycle events: %v", err)
+	}
+	return w, nil
+}
+
+// wait blocks until the current document of the main frame has fired the
+// named event.
+func (w *lifecycleWatcher) wait(ctx context.Context, name string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		tree, err := page.GetFrameTree().Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get frame tree: %v", err)
+		}
+		w.mu.Lock()
+		fired := w.seen[tree.Frame.LoaderID][name]
+		w.mu.Unlock()
+		if fired {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("%w waiting for lifecycle event %s after %v", errWaitTimeout, name, timeout)
+		case <-w.notify:
+		}
+	}
+}
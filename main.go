@@ -2,87 +2,351 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/chromedp/cdproto/emulation"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
-	"github.com/mitchellh/go-homedir"
+	"github.com/redis/go-redis/v9"
+	"github.com/stashme/cookieapi/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
-type Cookie struct {
-	Name   string `json:"name"`
-	Value  string `json:"value"`
-	Domain string `json:"domain"`
-	Path   string `json:"path"`
+// The request and response types are defined in the client package, so
+// Go callers share them with the server.
+type (
+	Cookie            = client.Cookie
+	Envelope          = client.Envelope
+	PageInfo          = client.PageInfo
+	IndexedDBInfo     = client.IndexedDBInfo
+	IndexedDBDatabase = client.IndexedDBDatabase
+	IndexedDBStore    = client.IndexedDBStore
+	EffectiveParams   = client.EffectiveParams
+
+	ScrollOptions      = client.ScrollOptions
+	AfterEventOptions  = client.AfterEventOptions
+	RetryOptions       = client.RetryOptions
+	FingerprintOptions = client.FingerprintOptions
+	ClientHints        = client.ClientHints
+	BrandVersion       = client.BrandVersion
+)
+
+// FetchResult is everything a single fetchCookies call collected.
+type FetchResult struct {
+	Cookies   []Cookie
+	Page      *PageInfo
+	MatchedBy string
+	Globals   map[string]json.RawMessage
+	RedisKey  string
+	IndexedDB *IndexedDBInfo
+	// HTML is the page's DOM for include_html.
+	HTML          string
+	HTMLTruncated bool
+	// Raw holds the CDP cookies behind Cookies, for raw=true.
+	Raw []*network.Cookie
+	// Total is the number of cookies before limit and offset were applied.
+	Total int
+	// Attempts is how many navigations retry_if_empty made.
+	Attempts int
+	// Partial is set when best_effort carried on after a wait timed out.
+	// Warning describes that, and a missing profile dir.
+	Partial bool
+	Warning string
+	// Params are the settings the fetch resolved, for echo_params.
+	Params *EffectiveParams
 }
 
+const (
+	envelopeVersion = 1
+	// envelopeMediaType selects the envelope through the Accept header.
+	envelopeMediaType = "application/vnd.cookieapi.v1+json"
+)
+
 type Config struct {
 	Chrome struct {
-		ProfileDir string `yaml:"profile_dir"`
+		ProfileDir  string `yaml:"profile_dir"`
+		CopyProfile bool   `yaml:"copy_profile"`
+		// KeepProfileOnError leaves the copy_profile dir of a failed fetch
+		// behind for inspection.
+		KeepProfileOnError bool `yaml:"keep_profile_on_error"`
+		// Profiles names additional user data dirs that requests can pick
+		// with profile; ProfileDir stays the default.
+		Profiles     map[string]string `yaml:"profiles"`
+		RemoteWSURL  string            `yaml:"remote_ws_url"`
+		MaxRedirects int               `yaml:"max_redirects"`
+		// AllowDownloads leaves Chrome's download behavior alone instead
+		// of failing fetches of file responses with NOT_A_PAGE, e.g. for a
+		// shared remote browser whose settings must not change.
+		AllowDownloads bool `yaml:"allow_downloads"`
+		// SchemeFallback retries over http when https fails to connect for
+		// a URL given without a scheme. Off by default as it lowers the
+		// transport security a client may be expecting.
+		SchemeFallback bool `yaml:"scheme_fallback"`
+		// AllowFileURLs and AllowDataURLs permit file:// and data: targets,
+		// meant for offline test fixtures. file:// exposes the server's
+		// filesystem to clients, so both are off by default.
+		AllowFileURLs bool `yaml:"allow_file_urls"`
+		AllowDataURLs bool `yaml:"allow_data_urls"`
+		Proxy         struct {
+			Server   string `yaml:"server"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		} `yaml:"proxy"`
+		// ClientCert and ClientKey are PEM files presented to the hosts in
+		// ClientCertHosts, or to the target's host when that is empty.
+		ClientCert      string   `yaml:"client_cert"`
+		ClientKey       string   `yaml:"client_key"`
+		ClientCertHosts []string `yaml:"client_cert_hosts"`
+		// DisableGPU turns off Chrome's GPU stack. Unset, it defaults to
+		// true when running in a container.
+		DisableGPU *bool `yaml:"disable_gpu"`
+		// MaxProfileCookies clears the cookies of a persistent profile at
+		// the start of a fetch once more than this many have accumulated.
+		MaxProfileCookies int `yaml:"max_profile_cookies"`
+		// ProfileLock takes a file lock on the profile dir for the duration
+		// of each fetch, waiting up to ProfileLockTimeout for it.
+		ProfileLock        bool          `yaml:"profile_lock"`
+		ProfileLockTimeout time.Duration `yaml:"profile_lock_timeout"`
+		// RequireProfileDir fails fetches whose profile dir doesn't exist
+		// instead of only warning that their cookies come from an empty
+		// profile.
+		RequireProfileDir bool `yaml:"require_profile_dir"`
+		// PollInterval spaces the checks of the network idle and URL
+		// pattern waits, each moved by up to PollJitter either way.
+		PollInterval time.Duration `yaml:"poll_interval"`
+		PollJitter   time.Duration `yaml:"poll_jitter"`
+		// ConsentSelectors and ConsentTexts extend the built-in lists of
+		// auto_accept_cookies.
+		ConsentSelectors []string `yaml:"consent_selectors"`
+		ConsentTexts     []string `yaml:"consent_texts"`
+		// ResourceLimits are best-effort hints passed to Chrome as flags.
+		ResourceLimits struct {
+			MaxOldSpaceMB        int `yaml:"max_old_space_mb"`
+			RendererProcessLimit int `yaml:"renderer_process_limit"`
+		} `yaml:"resource_limits"`
 	} `yaml:"chrome"`
 	Server struct {
-		IP   string `yaml:"ip"`
-		Port int    `yaml:"port"`
+		IP           string   `yaml:"ip"`
+		Port         int      `yaml:"port"`
+		StripCookies []string `yaml:"strip_cookies"`
+		// AuthNamePatterns and AuthIgnorePatterns replace the heuristics
+		// of auth_only, which keeps cookies scoring AuthMinScore or more.
+		AuthNamePatterns   []string `yaml:"auth_name_patterns"`
+		AuthIgnorePatterns []string `yaml:"auth_ignore_patterns"`
+		AuthMinScore       *float64 `yaml:"auth_min_score"`
+		UnixSocket         string   `yaml:"unix_socket"`
+		DefaultFormat      string   `yaml:"default_format"`
+		// DefaultAccept is assumed for requests without a specific Accept
+		// header.
+		DefaultAccept string `yaml:"default_accept"`
+		// APIKey, when set, must accompany every request and enables the
+		// admin endpoints.
+		APIKey string `yaml:"api_key"`
+		// TempDir holds the temp dirs of fetches; it is emptied on startup.
+		TempDir string `yaml:"temp_dir"`
+		// TrustedProxies lists the reverse proxies, as CIDRs or addresses,
+		// whose X-Forwarded-For and X-Real-IP headers are believed.
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// SigningKey is a PEM Ed25519 private key used to sign responses.
+		SigningKey string `yaml:"signing_key"`
+		// AuditLog is a file receiving one JSON line per request, rotated
+		// at AuditLogMaxMB and keeping AuditLogBackups old files.
+		AuditLog        string `yaml:"audit_log"`
+		AuditLogMaxMB   int    `yaml:"audit_log_max_mb"`
+		AuditLogBackups int    `yaml:"audit_log_backups"`
+		// RedisURL, when set, receives a copy of every fetch's cookies
+		// under RedisKeyPrefix followed by the URL.
+		RedisURL       string `yaml:"redis_url"`
+		RedisKeyPrefix string `yaml:"redis_key_prefix"`
+		// UIEnabled serves the web form at /.
+		UIEnabled bool `yaml:"ui_enabled"`
+		// MaxBatchConcurrency bounds the batch_concurrency of requests.
+		MaxBatchConcurrency int `yaml:"max_batch_concurrency"`
+		// MaxConcurrent caps simultaneous fetches; 0 means unlimited.
+		MaxConcurrent int `yaml:"max_concurrent"`
+		// MaxQueue caps how many requests may wait for a free slot.
+		MaxQueue int `yaml:"max_queue"`
+		// MaxInflight caps the fetch requests handled at once, queued ones
+		// included; 0 means unlimited.
+		MaxInflight int `yaml:"max_inflight"`
+		// PerDomainConcurrency caps the fetches running at once against
+		// one registrable domain, on top of MaxConcurrent.
+		PerDomainConcurrency int `yaml:"per_domain_concurrency"`
+		// QueueTimeout is how long a request waits for a slot before a 503.
+		QueueTimeout time.Duration `yaml:"queue_timeout"`
+		// AllowedRequestFlags names the Chrome flags requests may set
+		// through chrome_flags.
+		AllowedRequestFlags []string `yaml:"allowed_request_flags"`
+		// RequestTimeout bounds every request, answering 504 once it has
+		// passed; 0 means no limit beyond the fetch timeouts.
+		RequestTimeout time.Duration `yaml:"request_timeout"`
+		// OtelEndpoint is an OTLP/HTTP collector receiving a trace of every
+		// request and the stages of its fetch.
+		OtelEndpoint string `yaml:"otel_endpoint"`
 	} `yaml:"server"`
 }
 
+// RequestPayload is a fetch request as the client package sends it, plus
+// what the server derives while handling it.
 type RequestPayload struct {
-	URL      string `json:"url"`
-	Pattern  string `json:"pattern"`
-	Headless bool   `json:"headless"`
+	client.RequestPayload
+
+	// schemeAdded records that the client gave no scheme and ensureHTTPS
+	// picked https, which is what allows the http fallback.
+	schemeAdded bool
+	// spanContext is the request's span, which the fetch's spans join.
+	spanContext trace.SpanContext
+	// urlTimeout, set from PerURLTimeoutMS for each URL of a batch, caps
+	// the fetch's overall timeout.
+	urlTimeout time.Duration
 }
 
 var verbose bool
 
+// configFetchTimeout bounds how long loadConfig waits for a remote config.
+const configFetchTimeout = 10 * time.Second
+
+const (
+	fetchTimeout   = 60 * time.Second
+	patternTimeout = 30 * time.Second
+	// interactiveTimeout replaces both timeouts above in interactive mode,
+	// leaving a person enough time to complete a manual login.
+	interactiveTimeout = 10 * time.Minute
+)
+
+// progressFunc receives the name of each stage fetchCookies enters.
+type progressFunc func(stage string)
+
 func main() {
+	var configSource string
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+	flag.StringVar(&configSource, "config", "config.yaml", "Config file path, - for stdin, or an http(s):// URL")
 	flag.Parse()
 
-	config, err := loadConfig("config.yaml")
-	if err != nil {
-		log.Printf("Failed to load config, using defaults: %v", err)
+	// Only a missing config file falls back to the defaults. A config that
+	// exists but doesn't parse or validate would otherwise silently drop
+	// settings such as server.api_key.
+	config, err := loadConfig(configSource)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Config %s not found, using defaults", configSource)
+	} else if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	serverIP := config.Server.IP
-	if serverIP == "" {
-		serverIP = "0.0.0.0"
+	if err := tempDirs.init(config); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
-	serverPort := config.Server.Port
-	if serverPort == 0 {
-		serverPort = 8080
+
+	if redisSink, err = newCookieSink(config); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
-	listenAddr := fmt.Sprintf("%s:%d", serverIP, serverPort)
 
-	log.Printf("Starting server on %s", listenAddr)
+	fetchSlots = newFetchLimiter(config)
+	domainSlots = newDomainLimiter(config)
+	logDefaultProfileDir(config)
+
+	store := newConfigStore(configSource, config)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/fetch-cookies/", func(w http.ResponseWriter, r *http.Request) {
-		handleFetchCookies(w, r, config)
+	gate := &requestGate{}
+	mux.HandleFunc("/fetch-cookies/", recoverPanics(gate.admit(func(w http.ResponseWriter, r *http.Request) {
+		handleFetchCookies(w, r, store.Load())
+	}, store)))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(w, r, gate, fetchSlots)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		handleUI(w, r, store.Load())
+	})
+	mux.HandleFunc("/pubkey", func(w http.ResponseWriter, r *http.Request) {
+		handlePubkey(w, r, store.Load())
+	})
+	mux.HandleFunc("/profiles", func(w http.ResponseWriter, r *http.Request) {
+		handleProfiles(w, r, store.Load())
 	})
-	if err := http.ListenAndServe(listenAddr, mux); err != nil {
+	mux.HandleFunc("/admin/reload-config", func(w http.ResponseWriter, r *http.Request) {
+		handleReloadConfig(w, r, store)
+	})
+
+	listener, err := listen(config)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	log.Printf("Starting server on %s", listener.Addr())
+
+	shutdownTracing, err := setupTracing(config)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	handler, err := newAuditLog(withTracing(requireAPIKey(withRequestTimeout(mux, store), store)), config, store)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	srv := &http.Server{Handler: handler}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down cleanly: %v", err)
+		}
+	}()
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
+	// Serve returns as soon as shutdown starts; wait for in-flight fetches
+	// before removing the temp dirs they may still be using.
+	<-shutdownDone
+	tempDirs.removeAll()
 }
 
 func handleFetchCookies(w http.ResponseWriter, r *http.Request, config Config) {
+	if verbose {
+		log.Printf("%s %s from %s", r.Method, r.URL.Path, clientIP(r, config))
+	}
 	switch r.Method {
 	case http.MethodGet:
-		url := strings.TrimPrefix(r.URL.Path, "/fetch-cookies/")
-		if url == "" {
-			sendError(w, "Missing URL in path", http.StatusBadRequest)
+		url, err := getTargetURL(r)
+		if err != nil {
+			sendError(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
 			return
 		}
 
+		schemeAdded := !hasScheme(url)
 		url = ensureHTTPS(url)
 		if verbose {
 			log.Printf("Processing URL: %s", url)
@@ -91,15 +355,58 @@ func handleFetchCookies(w http.ResponseWriter, r *http.Request, config Config) {
 		if verbose {
 			log.Printf("Headless mode: %v", headless)
 		}
-		cookies, err := fetchCookies(url, "", headless, config)
-		if err != nil {
-			sendError(w, fmt.Sprintf("Failed to fetch cookies: %v", err), http.StatusInternalServerError)
-			return
+		payload := RequestPayload{
+			RequestPayload: client.RequestPayload{
+				URL:                   url,
+				Headless:              &headless,
+				SkipNetworkIdle:       queryBool(r, "skip_network_idle"),
+				AcceptLanguage:        r.URL.Query().Get("accept_language"),
+				AcceptEncoding:        r.URL.Query().Get("accept_encoding"),
+				ClearCookies:          queryBool(r, "clear_cookies"),
+				ClearExcept:           queryList(r, "clear_except"),
+				IncludePageInfo:       queryBool(r, "include_page_info"),
+				Referrer:              r.URL.Query().Get("referrer"),
+				Origin:                r.URL.Query().Get("origin"),
+				Scroll:                queryScroll(r),
+				NamePattern:           r.URL.Query().Get("name_pattern"),
+				NamePrefix:            r.URL.Query().Get("name_prefix"),
+				NameContains:          r.URL.Query().Get("name_contains"),
+				ValuePattern:          r.URL.Query().Get("value_pattern"),
+				CaseInsensitive:       queryBool(r, "case_insensitive"),
+				Path:                  r.URL.Query().Get("path"),
+				EncodeBinaryValues:    queryBool(r, "encode_binary_values"),
+				AuthOnly:              queryBool(r, "auth_only"),
+				RetryIfEmpty:          queryRetry(r),
+				Fingerprint:           queryFingerprint(r),
+				Profile:               r.URL.Query().Get("profile"),
+				WaitSelector:          r.URL.Query().Get("wait_selector"),
+				WaitResource:          r.URL.Query().Get("wait_resource"),
+				WaitMinCookies:        queryInt(r, "wait_min_cookies"),
+				WaitMinCookiesPattern: r.URL.Query().Get("wait_min_cookies_pattern"),
+				WarmupURL:             r.URL.Query().Get("warmup_url"),
+				NoDedup:               queryBool(r, "no_dedup"),
+				BestEffort:            queryBool(r, "best_effort"),
+				AutoAcceptCookies:     queryBool(r, "auto_accept_cookies"),
+				LifecycleEvent:        r.URL.Query().Get("lifecycle_event"),
+				NetworkIdleInflight:   queryInt(r, "network_idle_inflight"),
+				OnlyPersistent:        queryBool(r, "only_persistent"),
+				OnlySession:           queryBool(r, "only_session"),
+				NewContext:            queryBool(r, "new_context"),
+				AfterEvent:            queryAfterEvent(r),
+				ExtractGlobals:        queryList(r, "extract_globals"),
+				IncludeIndexedDB:      queryBool(r, "include_indexeddb"),
+				IncludeHTML:           queryBool(r, "include_html"),
+				HTMLMaxBytes:          queryInt(r, "html_max_bytes"),
+				TimeoutMS:             queryInt(r, "timeout_ms"),
+			},
+			schemeAdded: schemeAdded,
+			spanContext: trace.SpanContextFromContext(r.Context()),
 		}
-		if verbose {
-			log.Printf("Returning %d cookies for %s", len(cookies), url)
+		if err := validateRequest(r, payload, config); err != nil {
+			sendError(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
 		}
-		sendJSONResponse(w, cookies)
+		serveFetch(w, r, payload, config)
 
 	case http.MethodPost:
 		var payload RequestPayload
@@ -107,75 +414,466 @@ func handleFetchCookies(w http.ResponseWriter, r *http.Request, config Config) {
 			sendError(w, "Invalid JSON payload", http.StatusBadRequest)
 			return
 		}
+		payload.spanContext = trace.SpanContextFromContext(r.Context())
 
-		if payload.URL == "" || payload.Pattern == "" {
-			sendError(w, "URL and pattern are required", http.StatusBadRequest)
+		if err := validateRequest(r, payload, config); err != nil {
+			sendError(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(payload.URLs) > 0 {
+			serveBatch(w, r, payload, config)
 			return
 		}
 
 		url := ensureHTTPS(payload.URL)
+		payload.URL, payload.schemeAdded = url, !hasScheme(payload.URL)
 		if verbose {
 			log.Printf("Processing URL: %s", url)
 		}
-		if _, err := regexp.Compile(payload.Pattern); err != nil {
-			sendError(w, fmt.Sprintf("Invalid regex pattern: %v", err), http.StatusBadRequest)
+		if payload.Interactive && acceptsEventStream(r) {
+			streamFetchCookies(w, r, payload, config)
 			return
 		}
 
-		cookies, err := fetchCookies(url, payload.Pattern, payload.Headless, config)
-		if err != nil {
-			sendError(w, fmt.Sprintf("Failed to fetch cookies: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if verbose {
-			log.Printf("Returning %d cookies for %s", len(cookies), url)
-		}
-		sendJSONResponse(w, cookies)
+		serveFetch(w, r, payload, config)
 
 	default:
 		sendError(w, "Only GET and POST requests are supported", http.StatusMethodNotAllowed)
 	}
 }
 
-func fetchCookies(url, pattern string, headless bool, config Config) ([]Cookie, error) {
-	profileDir := config.Chrome.ProfileDir
-	if profileDir == "" {
-		profileDir = "~/AppData/Local/Google/Chrome/User Data/"
+// serveFetch runs a fetch that passed validateRequest and writes its result.
+func serveFetch(w http.ResponseWriter, r *http.Request, payload RequestPayload, config Config) {
+	format, _ := negotiateFormat(r, config)
+	result, err := fetchCookiesShared(r.Context(), payload, config)
+	if err != nil {
+		sendFetchError(w, err)
+		return
+	}
+	if verbose {
+		log.Printf("Returning %d cookies for %s", len(result.Cookies), payload.URL)
+	}
+	processResult(r, payload.URL, result)
+	setRedisKeyHeader(w, result.RedisKey)
+	if result.Attempts > 0 {
+		w.Header().Set("X-Fetch-Attempts", strconv.Itoa(result.Attempts))
 	}
+	if result.Partial {
+		w.Header().Set("X-Partial-Result", result.Warning)
+	}
+	if paged(r) {
+		w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	}
+	// The response is rendered first so its ETag covers exactly the bytes
+	// sent.
+	buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+	writeResponse(buf, r, format, payload.URL, result)
+	if buf.status == http.StatusOK && writeNotModified(w, r, bodyETag(buf.body.Bytes())) {
+		return
+	}
+	writeSigned(w, config, func(w http.ResponseWriter) {
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
 
-	profile, err := homedir.Expand(profileDir)
+// processResult applies what r asks of a single fetch's result before it is
+// written, whether as a response or as the final event of an interactive
+// stream: the Redis export, expiry_override, rewrite_domain, max_value_len
+// and the page of a paged request.
+func processResult(r *http.Request, url string, result *FetchResult) {
+	noteCookieCount(r, len(result.Cookies))
+	result.RedisKey = redisSink.export(url, result.Cookies)
+	overrideExpiries(r, result.Cookies)
+	rewriteDomains(r, result.Cookies)
+	truncateValues(r, result.Cookies)
+	sortCookies(result.Cookies)
+	sortRawCookies(result.Raw)
+	if paged(r) {
+		result.Total = len(result.Cookies)
+		result.Cookies = paginate(r, result.Cookies)
+	}
+}
+
+// rawCookiesOf returns the CDP cookies that kept, the cookies left after
+// stripping and filtering, were converted from.
+func rawCookiesOf(kept []Cookie, raw []*network.Cookie) []*network.Cookie {
+	keys := make(map[string]bool, len(kept))
+	for _, c := range kept {
+		keys[c.Name+"\x00"+c.Domain+"\x00"+c.Path] = true
+	}
+	var matched []*network.Cookie
+	for _, c := range raw {
+		if keys[c.Name+"\x00"+c.Domain+"\x00"+c.Path] {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// selectCookies converts the CDP cookies and keeps those the request asks
+// for, after server.strip_cookies, the filters and auth_only.
+func selectCookies(raw []*network.Cookie, payload RequestPayload, config Config) ([]Cookie, error) {
+	var cookies []Cookie
+	for _, c := range raw {
+		cookies = append(cookies, Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			HostOnly: isHostOnly(c.Domain),
+			SameSite: c.SameSite.String(),
+		})
+	}
+	if verbose {
+		log.Printf("Fetched %d cookies", len(cookies))
+	}
+
+	cookies, err := stripCookies(cookies, config.Server.StripCookies)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := cookieFilters(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to expand profile dir: %v", err)
+		return nil, err
+	}
+	cookies = applyFilters(cookies, filters)
+	if payload.AuthOnly {
+		if cookies, err = authCookies(cookies, config); err != nil {
+			return nil, err
+		}
+	}
+	return cookies, nil
+}
+
+// isHostOnly reports whether a CDP cookie domain denotes a host-only cookie.
+// CDP has no hostOnly attribute; instead it reports domain cookies, those
+// set with an explicit Domain attribute, with a leading dot.
+func isHostOnly(domain string) bool {
+	return !strings.HasPrefix(domain, ".")
+}
+
+// fetchCookies runs one fetch. Chrome, and every wait, stop once ctx is
+// done, e.g. when the client disconnects or server.request_timeout passes.
+func fetchCookies(ctx context.Context, payload RequestPayload, config Config, progress progressFunc) (_ *FetchResult, err error) {
+	url, pattern, selector := payload.URL, payload.Pattern, payload.WaitSelector
+	report := func(stage string) {
+		if progress != nil {
+			progress(stage)
+		}
+	}
+
+	traceCtx, span := tracer.Start(trace.ContextWithSpanContext(ctx, payload.spanContext), "fetchCookies",
+		trace.WithAttributes(attribute.String("url.full", url)))
+	defer func() { endSpan(span, err) }()
+
+	if err := checkLocalURL(url, config); err != nil {
+		return nil, err
+	}
+
+	headless := payload.Headless == nil || *payload.Headless
+	timeout, urlTimeout := fetchTimeout, patternTimeout
+	if payload.Interactive {
+		if verbose {
+			log.Printf("Interactive mode: forcing headful Chrome and a %v timeout", interactiveTimeout)
+		}
+		headless = false
+		timeout, urlTimeout = interactiveTimeout, interactiveTimeout
+	}
+	var budget *stageBudget
+	if payload.TimeoutMS > 0 {
+		total := time.Duration(payload.TimeoutMS) * time.Millisecond
+		timeout = total + budgetGrace
+		weights := []int{navigationWeight}
+		if pattern != "" || selector != "" {
+			weights = append(weights, patternWaitWeight)
+		}
+		if payload.LifecycleEvent != "" || !payload.SkipNetworkIdle {
+			weights = append(weights, idleWaitWeight)
+		}
+		budget = newStageBudget(total, weights...)
+	}
+
+	profile, err := profileDir(config, payload.Profile)
+	if err != nil {
+		return nil, err
 	}
 	if verbose {
 		log.Printf("Using Chrome profile directory: %s", profile)
 	}
+	// warnings lists what the result should be read with: a missing
+	// profile dir, the waits that timed out without failing the fetch and
+	// globals that didn't serialize.
+	var warnings []string
+	// A remote browser's profile isn't on this machine, and new_context
+	// doesn't use the profile's cookies anyway.
+	if config.Chrome.RemoteWSURL == "" && !payload.NewContext {
+		warning, err := checkProfileDir(profile, config)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	defer useProfile(profile)()
+	unlockProfile := func() {}
+	if config.Chrome.RemoteWSURL == "" {
+		if unlockProfile, err = lockProfile(ctx, profile, config); err != nil {
+			return nil, err
+		}
+		defer unlockProfile()
+	}
+	if config.Chrome.CopyProfile && config.Chrome.RemoteWSURL == "" {
+		copied, err := copyProfile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy profile: %v", err)
+		}
+		defer func() {
+			if err != nil && config.Chrome.KeepProfileOnError {
+				tempDirs.keep(copied)
+				log.Printf("KEEPING profile copy %s of the failed fetch of %s for inspection", copied, url)
+				err = fmt.Errorf("%w (profile copy kept at %s)", err, copied)
+				return
+			}
+			tempDirs.remove(copied)
+		}()
+		profile = copied
+	}
+	// Chrome runs against the copy, so others may use the profile again.
+	if config.Chrome.CopyProfile {
+		unlockProfile()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	if payload.urlTimeout > 0 && payload.urlTimeout < timeout {
+		timeout = payload.urlTimeout
+	}
+	params := effectiveParams(payload, config, headless, timeout, urlTimeout, budget)
+	poll := newPollTiming(config)
+	ctx, cancel := context.WithTimeout(traceCtx, timeout)
 	defer cancel()
 
-	browserCtx, cancel, err := setupChromeContext(ctx, profile, headless)
+	// The domain slot comes first: fetches queued behind a busy domain
+	// mustn't hold global slots that other domains could use.
+	release, err := domainSlots.acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	releaseSlot, err := fetchSlots.take(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseSlot()
+
+	browserCtx, cancel, err := setupChromeContext(ctx, profile, headless, payload.ChromeFlags, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup Chrome context: %v", err)
 	}
 	defer cancel()
+	if payload.NewContext {
+		browserCtx, cancel, err = newIsolatedContext(browserCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+	}
+
+	// Actions run under a child context so the redirect guard and crash
+	// watcher can abort them with a cause without tearing down the browser
+	// itself; the deferred cancel then shuts the browser and allocator down.
+	runCtx, abort := context.WithCancelCause(browserCtx)
+	defer abort(nil)
+	redirects := newRedirectGuard(config, abort)
+	redirects.listen(browserCtx)
+	watchForCrash(browserCtx, abort)
+	var downloads *downloadGuard
+	if !config.Chrome.AllowDownloads {
+		downloads = newDownloadGuard(abort)
+		downloads.listen(browserCtx)
+	}
 
 	var rawCookies []*network.Cookie
-	actions := []chromedp.Action{
-		chromedp.ActionFunc(func(ctx context.Context) error {
+	var matchedBy string
+	// partial is set once a wait timed out without failing the fetch.
+	partial := false
+	// waitFailed ends the fetch with a wait's error, unless best_effort
+	// lets it carry on after a timeout.
+	waitFailed := func(err error) error {
+		if !payload.BestEffort || !errors.Is(err, errWaitTimeout) {
+			return err
+		}
+		log.Printf("Continuing after %v (best_effort)", err)
+		warnings = append(warnings, err.Error())
+		partial = true
+		return nil
+	}
+	actions := []chromedp.Action{redirects.enable()}
+	auth := newProxyAuth(config)
+	certs, err := newClientCertFetcher(config, url)
+	if err != nil {
+		return nil, err
+	}
+	post := newNavPost(payload)
+	if auth != nil || certs != nil || post != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return enableInterception(ctx, auth, certs, post)
+		}))
+	}
+	if downloads != nil {
+		actions = append(actions, downloads.deny())
+	}
+	if headers := extraHeaders(payload); len(headers) > 0 {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Setting %d extra request headers", len(headers))
+			}
+			if err := enableNetwork(ctx); err != nil {
+				return err
+			}
+			return network.SetExtraHTTPHeaders(headers).Do(ctx)
+		}))
+	}
+	if payload.Fingerprint != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return applyFingerprint(ctx, *payload.Fingerprint)
+		}))
+	}
+	if locale := localeFromAcceptLanguage(payload.AcceptLanguage); locale != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Overriding locale: %s", locale)
+			}
+			return emulation.SetLocaleOverride().WithLocale(locale).Do(ctx)
+		}))
+	}
+	// Copies and fresh contexts don't persist, and clear_cookies empties
+	// the profile anyway.
+	if max := config.Chrome.MaxProfileCookies; max > 0 && !config.Chrome.CopyProfile && !payload.NewContext && !payload.ClearCookies {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return resetFullProfile(ctx, max, profile)
+		}))
+	}
+	if payload.ClearCookies {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return clearBrowserCookies(ctx, payload.ClearExcept)
+		}))
+	}
+	if len(payload.SeedCookies) > 0 {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return seedCookies(ctx, payload.SeedCookies, url)
+		}))
+	}
+	if warmup := payload.WarmupURL; warmup != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Warming up at %s", warmup)
+			}
+			report("warming_up")
+			if err := chromedp.Navigate(warmup).Do(ctx); err != nil {
+				return fmt.Errorf("failed to navigate to warmup_url: %v", err)
+			}
+			if err := chromedp.WaitVisible("body", chromedp.ByQuery).Do(ctx); err != nil {
+				return fmt.Errorf("failed to load warmup_url: %v", err)
+			}
+			// Challenge scripts typically set their cookies from
+			// follow-up requests, so let those finish too.
+			if !payload.SkipNetworkIdle {
+				if err := waitForNetworkIdle(ctx, poll, 2*time.Second, 30*time.Second, payload.NetworkIdleInflight); err != nil {
+					return fmt.Errorf("failed to wait for network idle on warmup_url: %v", err)
+				}
+			}
+			return nil
+		}))
+	}
+	var resource *resourceWatcher
+	if payload.WaitResource != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			resource, err = watchForResource(ctx, payload.WaitResource)
+			return err
+		}))
+	}
+	var afterEvent *eventWatcher
+	if payload.AfterEvent != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			afterEvent, err = watchForEvent(ctx, *payload.AfterEvent)
+			return err
+		}))
+	}
+	var lifecycle *lifecycleWatcher
+	if payload.LifecycleEvent != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			lifecycle, err = watchLifecycle(ctx)
+			return err
+		}))
+	}
+	actions = append(actions,
+		traced("navigate", func(ctx context.Context) (err error) {
 			if verbose {
 				log.Printf("Navigating to %s", url)
 			}
-			return chromedp.Navigate(url).Do(ctx)
+			report("navigating")
+			post.arm()
+			if budget != nil {
+				share := budget.allot("navigation", navigationWeight, 0)
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, share)
+				defer cancel()
+				defer func() {
+					if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+						err = fmt.Errorf("navigation exceeded its %v share of timeout_ms", share)
+					}
+				}()
+			}
+			err = chromedp.Navigate(url).Do(ctx)
+			if err != nil && config.Chrome.SchemeFallback && payload.schemeAdded && isSchemeFallbackError(err) {
+				httpURL := "http://" + strings.TrimPrefix(url, "https://")
+				log.Printf("HTTPS navigation to %s failed (%v), falling back to %s", url, err, httpURL)
+				return chromedp.Navigate(httpURL).Do(ctx)
+			}
+			return err
 		}),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			if pattern != "" {
+		traced("wait_pattern", func(ctx context.Context) error {
+			urlTimeout := urlTimeout
+			if pattern != "" || selector != "" {
+				urlTimeout = budget.allot("pattern wait", patternWaitWeight, urlTimeout)
+			}
+			switch {
+			case pattern != "" && selector != "":
+				if verbose {
+					log.Printf("Waiting for URL to match pattern %s or selector %s", pattern, selector)
+				}
+				report("waiting_for_pattern_or_selector")
+				matched, err := waitForPatternOrSelector(ctx, poll, pattern, selector, urlTimeout)
+				if err != nil {
+					return waitFailed(fmt.Errorf("failed to wait for URL pattern or selector: %w", err))
+				}
+				matchedBy = matched
+			case pattern != "":
 				if verbose {
 					log.Printf("Waiting for URL to match pattern: %s", pattern)
 				}
-				if err := waitForURLPattern(ctx, pattern, 30*time.Second); err != nil {
-					return fmt.Errorf("failed to wait for URL pattern: %v", err)
+				report("waiting_for_pattern")
+				if err := waitForURLPattern(ctx, poll, pattern, urlTimeout); err != nil {
+					return waitFailed(fmt.Errorf("failed to wait for URL pattern: %w", err))
+				}
+				matchedBy = matchedPattern
+			case selector != "":
+				if verbose {
+					log.Printf("Waiting for selector: %s", selector)
 				}
+				report("waiting_for_selector")
+				if err := waitForSelector(ctx, selector, urlTimeout); err != nil {
+					return waitFailed(fmt.Errorf("failed to wait for selector: %w", err))
+				}
+				matchedBy = matchedSelector
 			}
 			return nil
 		}),
@@ -183,21 +881,163 @@ func fetchCookies(url, pattern string, headless bool, config Config) ([]Cookie,
 			if verbose {
 				log.Printf("Waiting for page body to load")
 			}
+			report("waiting_for_body")
 			return chromedp.WaitVisible("body", chromedp.ByQuery).Do(ctx)
 		}),
-		chromedp.ActionFunc(func(ctx context.Context) error {
+	)
+	if n := payload.WaitMinCookies; n > 0 {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Waiting for at least %d cookies", n)
+			}
+			report("waiting_for_cookies")
+			if err := waitForMinCookies(ctx, n, payload.WaitMinCookiesPattern, minCookiesTimeout); err != nil {
+				return waitFailed(fmt.Errorf("failed to wait for cookies: %w", err))
+			}
+			return nil
+		}))
+	}
+	if payload.AutoAcceptCookies {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			report("accepting_cookies")
+			return autoAcceptCookies(ctx, config)
+		}))
+	}
+	if len(payload.ClickSelectors) > 0 {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Clicking %d selectors", len(payload.ClickSelectors))
+			}
+			report("clicking")
+			return clickSelectors(ctx, payload.ClickSelectors, payload.ClickOptional)
+		}))
+	}
+	if payload.Scroll != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Scrolling to trigger lazy content")
+			}
+			report("scrolling")
+			if err := scrollPage(ctx, *payload.Scroll); err != nil {
+				return fmt.Errorf("failed to scroll page: %v", err)
+			}
+			return nil
+		}))
+	}
+	switch {
+	case payload.LifecycleEvent != "":
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Waiting for lifecycle event %s", payload.LifecycleEvent)
+			}
+			report("waiting_for_lifecycle_event")
+			timeout := budget.allot("lifecycle wait", idleWaitWeight, 30*time.Second)
+			if err := lifecycle.wait(ctx, payload.LifecycleEvent, timeout); err != nil {
+				return waitFailed(fmt.Errorf("failed to wait for lifecycle event: %w", err))
+			}
+			return nil
+		}))
+	case payload.SkipNetworkIdle:
+		if verbose {
+			log.Printf("Skipping network idle wait")
+		}
+	default:
+		actions = append(actions, traced("wait_network_idle", func(ctx context.Context) error {
 			if verbose {
 				log.Printf("Waiting for network idle")
 			}
-			if err := waitForNetworkIdle(ctx, 2*time.Second, 30*time.Second); err != nil {
-				return fmt.Errorf("failed to wait for network idle: %v", err)
+			report("waiting_for_network_idle")
+			timeout := budget.allot("network idle wait", idleWaitWeight, 30*time.Second)
+			if err := waitForNetworkIdle(ctx, poll, 2*time.Second, timeout, payload.NetworkIdleInflight); err != nil {
+				return waitFailed(fmt.Errorf("failed to wait for network idle: %w", err))
 			}
 			return nil
-		}),
-		chromedp.ActionFunc(func(ctx context.Context) error {
+		}))
+	}
+	var pageInfo *PageInfo
+	if payload.IncludePageInfo {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Capturing page info")
+			}
+			info, err := capturePageInfo(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to capture page info: %v", err)
+			}
+			pageInfo = info
+			return nil
+		}))
+	}
+	if payload.WaitResource != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Waiting for a resource containing %q to load", payload.WaitResource)
+			}
+			report("waiting_for_resource")
+			if err := resource.wait(ctx); err != nil {
+				return waitFailed(fmt.Errorf("failed to wait for wait_resource: %w", err))
+			}
+			return nil
+		}))
+	}
+	if payload.AfterEvent != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			report("waiting_for_event")
+			if err := afterEvent.wait(ctx); err != nil {
+				return waitFailed(fmt.Errorf("failed to wait for after_event_delay: %w", err))
+			}
+			return nil
+		}))
+	}
+	var globals map[string]json.RawMessage
+	if len(payload.ExtractGlobals) > 0 {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Extracting %d globals", len(payload.ExtractGlobals))
+			}
+			values, invalid, err := extractGlobals(ctx, payload.ExtractGlobals)
+			if err != nil {
+				return fmt.Errorf("failed to extract globals: %v", err)
+			}
+			globals = values
+			warnings = append(warnings, invalid...)
+			return nil
+		}))
+	}
+	var indexedDB *IndexedDBInfo
+	if payload.IncludeIndexedDB {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Listing IndexedDB databases")
+			}
+			info, err := captureIndexedDB(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list IndexedDB databases: %v", err)
+			}
+			indexedDB = info
+			return nil
+		}))
+	}
+	var html string
+	var htmlTruncated bool
+	if payload.IncludeHTML {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if verbose {
+				log.Printf("Capturing the page HTML")
+			}
+			var err error
+			if html, htmlTruncated, err = captureHTML(ctx, payload.HTMLMaxBytes); err != nil {
+				return fmt.Errorf("failed to capture the page HTML: %v", err)
+			}
+			return nil
+		}))
+	}
+	actions = append(actions,
+		traced("get_cookies", func(ctx context.Context) error {
 			if verbose {
 				log.Printf("Fetching cookies")
 			}
+			report("fetching_cookies")
 			cookies, err := network.GetCookies().Do(ctx)
 			if err != nil {
 				return fmt.Errorf("failed to fetch cookies: %v", err)
@@ -205,29 +1045,101 @@ func fetchCookies(url, pattern string, headless bool, config Config) ([]Cookie,
 			rawCookies = cookies
 			return nil
 		}),
+	)
+	var attempts int
+	if payload.RetryIfEmpty != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			// A retry navigates and collects like the first attempt but
+			// skips the other waits; an idle wait that times out doesn't
+			// fail it, since the next attempt may still find cookies.
+			refetch := func(ctx context.Context) ([]*network.Cookie, error) {
+				report("retrying")
+				post.arm()
+				if err := chromedp.Navigate(url).Do(ctx); err != nil {
+					return nil, err
+				}
+				if err := chromedp.WaitVisible("body", chromedp.ByQuery).Do(ctx); err != nil {
+					return nil, err
+				}
+				if !payload.SkipNetworkIdle {
+					err := waitForNetworkIdle(ctx, poll, 2*time.Second, 30*time.Second, payload.NetworkIdleInflight)
+					if err != nil && !errors.Is(err, errWaitTimeout) {
+						return nil, err
+					}
+				}
+				return network.GetCookies().Do(ctx)
+			}
+			selected := func(raw []*network.Cookie) ([]Cookie, error) {
+				return selectCookies(raw, payload, config)
+			}
+			raw, n, err := retryFetch(ctx, *payload.RetryIfEmpty, rawCookies, selected, refetch)
+			attempts = n
+			if err != nil {
+				return err
+			}
+			rawCookies = raw
+			return nil
+		}))
 	}
 
-	if err := chromedp.Run(browserCtx, actions...); err != nil {
+	err = chromedp.Run(runCtx, actions...)
+	if auth != nil && auth.wasRejected() {
+		return nil, errProxyAuthRejected
+	}
+	if downloads != nil && err != nil {
+		if notPage := downloads.failure(); notPage != nil {
+			return nil, notPage
+		}
+	}
+	if cause := context.Cause(runCtx); err != nil && errorCode(cause) != "" {
+		return nil, cause
+	}
+	if err != nil {
+		if payload.Interactive && browserCtx.Err() != nil && ctx.Err() == nil {
+			if pattern == "" {
+				return nil, fmt.Errorf("browser was closed before selector %s appeared", selector)
+			}
+			return nil, fmt.Errorf("browser was closed before the URL matched pattern %s", pattern)
+		}
+		if certErr := clientCertError(err, certs); certErr != nil {
+			return nil, certErr
+		}
+		// A browser context that ended on its own, before our deadline,
+		// means the Chrome process exited or the connection to it dropped.
+		if isCrashError(err) || (browserCtx.Err() != nil && ctx.Err() == nil) {
+			return nil, chromeCrashed(err)
+		}
 		return nil, fmt.Errorf("failed to navigate or fetch cookies: %v", err)
 	}
 
-	var cookies []Cookie
-	for _, c := range rawCookies {
-		cookies = append(cookies, Cookie{
-			Name:   c.Name,
-			Value:  c.Value,
-			Domain: c.Domain,
-			Path:   c.Path,
-		})
+	cookies, err := selectCookies(rawCookies, payload, config)
+	if err != nil {
+		return nil, err
 	}
-	if verbose {
-		log.Printf("Fetched %d cookies", len(cookies))
+	if payload.EncodeBinaryValues {
+		encodeBinaryValues(cookies)
 	}
 
-	return cookies, nil
+	return &FetchResult{
+		Cookies:       cookies,
+		Page:          pageInfo,
+		MatchedBy:     matchedBy,
+		Globals:       globals,
+		IndexedDB:     indexedDB,
+		HTML:          html,
+		HTMLTruncated: htmlTruncated,
+		Raw:           rawCookiesOf(cookies, rawCookies),
+		Attempts:      attempts,
+		Partial:       partial,
+		Warning:       strings.Join(warnings, "; "),
+		Params:        params,
+	}, nil
 }
 
-func setupChromeContext(parentCtx context.Context, profile string, headless bool) (context.Context, context.CancelFunc, error) {
+func setupChromeContext(parentCtx context.Context, profile string, headless bool, flags []string, config Config) (context.Context, context.CancelFunc, error) {
+	if remote := config.Chrome.RemoteWSURL; remote != "" {
+		return setupRemoteChromeContext(parentCtx, remote)
+	}
 	if verbose {
 		log.Printf("Initializing Chrome with headless=%v", headless)
 	}
@@ -237,14 +1149,128 @@ func setupChromeContext(parentCtx context.Context, profile string, headless bool
 		chromedp.NoDefaultBrowserCheck,
 		chromedp.UserDataDir(profile),
 	)
+	if proxy := config.Chrome.Proxy.Server; proxy != "" {
+		if verbose {
+			log.Printf("Using proxy server: %s", proxy)
+		}
+		opts = append(opts, chromedp.ProxyServer(proxy))
+	}
+	if shouldDisableGPU(config) {
+		opts = append(opts, disableGPUFlags...)
+	}
+	limits := config.Chrome.ResourceLimits
+	if limits.MaxOldSpaceMB > 0 {
+		opts = append(opts, chromedp.Flag("js-flags", fmt.Sprintf("--max-old-space-size=%d", limits.MaxOldSpaceMB)))
+	}
+	if limits.RendererProcessLimit > 0 {
+		opts = append(opts, chromedp.Flag("renderer-process-limit", limits.RendererProcessLimit))
+	}
+	// Request flags come last so they override the defaults above.
+	opts = append(opts, chromeFlagOptions(flags)...)
 
 	allocCtx, cancel := chromedp.NewExecAllocator(parentCtx, opts...)
 	browserCtx, browserCancel := chromedp.NewContext(allocCtx, chromedp.WithLogf(log.Printf))
-	return browserCtx, func() { browserCancel(); cancel() }, nil
+	closeAll := func() { browserCancel(); cancel() }
+	// Start the browser now, on the context that owns it. Later runs may
+	// then use derived contexts without their cancellation killing Chrome.
+	if err := chromedp.Run(browserCtx); err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("failed to start Chrome: %v", err)
+	}
+	return browserCtx, closeAll, nil
+}
+
+// newIsolatedContext opens a tab in a new browser context of the browser
+// behind browserCtx. It shares the Chrome process but, like an incognito
+// window, none of the profile's cookies or storage; cancelling it disposes
+// the context along with everything the fetch stored in it.
+func newIsolatedContext(browserCtx context.Context) (context.Context, context.CancelFunc, error) {
+	if verbose {
+		log.Printf("Creating a new browser context")
+	}
+	tabCtx, cancel := chromedp.NewContext(browserCtx, chromedp.WithNewBrowserContext())
+	if err := chromedp.Run(tabCtx); err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to create browser context: %v", err)
+	}
+	return tabCtx, cancel, nil
+}
+
+// setupRemoteChromeContext attaches to an already running browser over its
+// DevTools websocket instead of launching one. Launch-time settings such as
+// the profile dir, headless mode and proxy belong to the remote browser.
+func setupRemoteChromeContext(parentCtx context.Context, remote string) (context.Context, context.CancelFunc, error) {
+	u, err := url.Parse(remote)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return nil, nil, fmt.Errorf("invalid chrome.remote_ws_url %q: expected a ws:// or wss:// URL", remote)
+	}
+	if verbose {
+		log.Printf("Connecting to remote Chrome at %s", remote)
+	}
+
+	allocCtx, cancel := chromedp.NewRemoteAllocator(parentCtx, remote)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx, chromedp.WithLogf(log.Printf))
+	closeAll := func() { browserCancel(); cancel() }
+	// Running no actions forces the connection so an unreachable endpoint is
+	// reported here rather than as a confusing navigation failure.
+	if err := chromedp.Run(browserCtx); err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("failed to connect to remote Chrome at %s: %v", remote, err)
+	}
+	return browserCtx, closeAll, nil
+}
+
+// schemeFallbackErrors are the navigation errors that suggest the host
+// doesn't speak https at all, as opposed to being down or slow.
+var schemeFallbackErrors = []string{
+	"net::ERR_CONNECTION_REFUSED",
+	"net::ERR_CONNECTION_RESET",
+	"net::ERR_CONNECTION_CLOSED",
+	"net::ERR_EMPTY_RESPONSE",
+	"net::ERR_SSL_",
+	"net::ERR_CERT_",
+}
+
+func isSchemeFallbackError(err error) bool {
+	for _, s := range schemeFallbackErrors {
+		if strings.Contains(err.Error(), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScheme(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || localScheme(url) != ""
+}
+
+// localScheme returns "file" or "data" for URLs that load without a network,
+// and "" otherwise.
+func localScheme(url string) string {
+	switch {
+	case strings.HasPrefix(url, "file://"):
+		return "file"
+	case strings.HasPrefix(url, "data:"):
+		return "data"
+	}
+	return ""
+}
+
+// checkLocalURL rejects file:// and data: URLs unless the config allows them.
+func checkLocalURL(url string, config Config) error {
+	allowed := map[string]bool{"file": config.Chrome.AllowFileURLs, "data": config.Chrome.AllowDataURLs}
+	if scheme := localScheme(url); scheme != "" && !allowed[scheme] {
+		return &codedError{
+			Code:   "SCHEME_NOT_ALLOWED",
+			Status: http.StatusBadRequest,
+			Err:    fmt.Errorf("%s: URLs are disabled, set chrome.allow_%s_urls to enable them", scheme, scheme),
+		}
+	}
+	return nil
 }
 
 func ensureHTTPS(url string) string {
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+	if !hasScheme(url) {
 		if verbose {
 			log.Printf("Added https scheme: %s", "https://"+url)
 		}
@@ -253,36 +1279,193 @@ func ensureHTTPS(url string) string {
 	return url
 }
 
+// queryBool reports whether the named query parameter parses as true.
+func queryBool(r *http.Request, name string) bool {
+	v, err := strconv.ParseBool(r.URL.Query().Get(name))
+	return err == nil && v
+}
+
+// wantsEnvelope reports whether the client asked for the versioned envelope,
+// either with ?envelope=true, by accepting its vendor media type, or by
+// asking for a field only the envelope carries.
+func wantsEnvelope(r *http.Request) bool {
+	return queryBool(r, "envelope") ||
+		strings.Contains(r.Header.Get("Accept"), envelopeMediaType) ||
+		queryBool(r, "include_header") || queryBool(r, "echo_params")
+}
+
+// newEnvelope wraps result, adding the optional fields r asks for.
+func newEnvelope(r *http.Request, url string, result *FetchResult) Envelope {
+	cookies := result.Cookies
+	if cookies == nil {
+		cookies = []Cookie{}
+	}
+	for i := range cookies {
+		cookies[i].ID = cookieID(cookies[i])
+	}
+	env := Envelope{
+		Version:       envelopeVersion,
+		URL:           url,
+		Count:         len(cookies),
+		Cookies:       cookies,
+		Page:          result.Page,
+		MatchedBy:     result.MatchedBy,
+		Globals:       result.Globals,
+		IndexedDB:     result.IndexedDB,
+		HTML:          result.HTML,
+		HTMLTruncated: result.HTMLTruncated,
+		RedisKey:      result.RedisKey,
+		Total:         result.Total,
+		Attempts:      result.Attempts,
+		Partial:       result.Partial,
+		Warning:       result.Warning,
+	}
+	if queryBool(r, "include_header") {
+		env.CookieHeader = cookieHeader(cookies)
+	}
+	if queryBool(r, "echo_params") {
+		env.Params = result.Params
+	}
+	return env
+}
+
+// cookieID returns a stable identity for a cookie: the hex-encoded SHA-256
+// of its name, domain and path joined by NUL bytes. Those three attributes
+// form a cookie's unique key per RFC 6265.
+func cookieID(c Cookie) string {
+	sum := sha256.Sum256([]byte(c.Name + "\x00" + c.Domain + "\x00" + c.Path))
+	return hex.EncodeToString(sum[:])
+}
+
+// queryScroll builds ScrollOptions from ?scroll=true and the optional
+// scroll_max and scroll_delay_ms parameters. Invalid numbers are passed on
+// as -1 so validation reports them.
+func queryScroll(r *http.Request) *ScrollOptions {
+	if !queryBool(r, "scroll") {
+		return nil
+	}
+	return &ScrollOptions{
+		MaxScrolls:  queryInt(r, "scroll_max"),
+		StepDelayMS: queryInt(r, "scroll_delay_ms"),
+	}
+}
+
+// queryFingerprint reads ?fingerprint, which names a preset.
+func queryFingerprint(r *http.Request) *FingerprintOptions {
+	preset := r.URL.Query().Get("fingerprint")
+	if preset == "" {
+		return nil
+	}
+	return &FingerprintOptions{Preset: preset}
+}
+
+// queryRetry reads ?retry_if_empty=true with retry_max_attempts,
+// retry_delay_ms and retry_require.
+func queryRetry(r *http.Request) *RetryOptions {
+	if !queryBool(r, "retry_if_empty") {
+		return nil
+	}
+	return &RetryOptions{
+		MaxAttempts: queryInt(r, "retry_max_attempts"),
+		DelayMS:     queryInt(r, "retry_delay_ms"),
+		Require:     queryList(r, "retry_require"),
+	}
+}
+
+// queryInt parses an integer query parameter, returning 0 when it is absent
+// and -1 when it is not a number.
+func queryInt(r *http.Request, name string) int {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// queryList splits a comma-separated query parameter, dropping empty items.
+func queryList(r *http.Request, name string) []string {
+	var items []string
+	for _, item := range strings.Split(r.URL.Query().Get(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func sendError(w http.ResponseWriter, message string, statusCode int) {
 	log.Printf("Error: %s (Status: %d)", message, statusCode)
 	http.Error(w, message, statusCode)
 }
 
 func sendJSONResponse(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		sendError(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
-func waitForNetworkIdle(ctx context.Context, idleDuration, maxTimeout time.Duration) error {
+// maxReportedPending caps how many outstanding request URLs a network idle
+// timeout error lists.
+const maxReportedPending = 5
+
+// maxNetworkIdleInflight bounds network_idle_inflight, after Puppeteer's
+// networkidle0 and networkidle2.
+const maxNetworkIdleInflight = 2
+
+type pendingRequest struct {
+	url     string
+	started time.Time
+}
+
+// waitForNetworkIdle waits until at most maxInflight requests have been
+// outstanding for idleDuration without a break.
+func waitForNetworkIdle(ctx context.Context, poll pollTiming, idleDuration, maxTimeout time.Duration, maxInflight int) error {
 	var mu sync.Mutex
-	lastRequestTime := time.Now()
+	// idleSince is when the pending count last dropped to maxInflight or
+	// below, zero while it is above.
+	idleSince := time.Now()
+	pending := make(map[network.RequestID]pendingRequest)
+	finished := func(id network.RequestID) {
+		delete(pending, id)
+		if len(pending) <= maxInflight && idleSince.IsZero() {
+			idleSince = time.Now()
+		}
+	}
 
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		if _, ok := ev.(*network.EventRequestWillBeSent); ok {
-			mu.Lock()
-			lastRequestTime = time.Now()
-			mu.Unlock()
+	// The listener lives only as long as this wait, so the waits of one
+	// fetch don't pile up listeners on its target.
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	chromedp.ListenTarget(listenCtx, func(ev interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			// A redirect reuses the request ID, so it doesn't add one.
+			pending[ev.RequestID] = pendingRequest{url: ev.Request.URL, started: time.Now()}
+			if len(pending) > maxInflight {
+				idleSince = time.Time{}
+			}
+		case *network.EventLoadingFinished:
+			finished(ev.RequestID)
+		case *network.EventLoadingFailed:
+			finished(ev.RequestID)
 		}
 	})
 
-	if err := chromedp.Run(ctx, network.Enable()); err != nil {
-		return fmt.Errorf("failed to enable network events: %v", err)
+	if err := enableNetwork(ctx); err != nil {
+		return err
 	}
 
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	timer := time.NewTimer(poll.next())
+	defer timer.Stop()
 
 	timeout := time.After(maxTimeout)
 
@@ -291,26 +1474,50 @@ func waitForNetworkIdle(ctx context.Context, idleDuration, maxTimeout time.Durat
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-timeout:
-			return fmt.Errorf("timeout waiting for network idle after %v", maxTimeout)
-		case <-ticker.C:
 			mu.Lock()
-			idle := time.Since(lastRequestTime) >= idleDuration
+			defer mu.Unlock()
+			if len(pending) == 0 {
+				return fmt.Errorf("%w waiting for network idle after %v", errWaitTimeout, maxTimeout)
+			}
+			return fmt.Errorf("%w waiting for network idle after %v, %d requests still pending, most recent: %s",
+				errWaitTimeout, maxTimeout, len(pending), strings.Join(recentPending(pending, maxReportedPending), ", "))
+		case <-timer.C:
+			mu.Lock()
+			idle := !idleSince.IsZero() && time.Since(idleSince) >= idleDuration
 			mu.Unlock()
 			if idle {
 				return nil
 			}
+			timer.Reset(poll.next())
 		}
 	}
 }
 
-func waitForURLPattern(ctx context.Context, pattern string, timeout time.Duration) error {
+// recentPending returns the URLs of the n most recently started requests.
+func recentPending(pending map[network.RequestID]pendingRequest, n int) []string {
+	requests := make([]pendingRequest, 0, len(pending))
+	for _, p := range pending {
+		requests = append(requests, p)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].started.After(requests[j].started) })
+	if len(requests) > n {
+		requests = requests[:n]
+	}
+	urls := make([]string, len(requests))
+	for i, p := range requests {
+		urls[i] = p.url
+	}
+	return urls
+}
+
+func waitForURLPattern(ctx context.Context, poll pollTiming, pattern string, timeout time.Duration) error {
 	regex, err := regexp.Compile(pattern)
 	if err != nil {
 		return fmt.Errorf("failed to compile regex pattern: %v", err)
 	}
 
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	timer := time.NewTimer(poll.next())
+	defer timer.Stop()
 
 	timeoutChan := time.After(timeout)
 
@@ -319,8 +1526,8 @@ func waitForURLPattern(ctx context.Context, pattern string, timeout time.Duratio
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-timeoutChan:
-			return fmt.Errorf("timeout waiting for URL to match pattern %s after %v", pattern, timeout)
-		case <-ticker.C:
+			return fmt.Errorf("%w waiting for URL to match pattern %s after %v", errWaitTimeout, pattern, timeout)
+		case <-timer.C:
 			var currentURL string
 			err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
 				currentIndex, entries, cmdErr := page.GetNavigationHistory().Do(ctx)
@@ -341,18 +1548,114 @@ func waitForURLPattern(ctx context.Context, pattern string, timeout time.Duratio
 				}
 				return nil
 			}
+			timer.Reset(poll.next())
 		}
 	}
 }
 
-func loadConfig(filename string) (Config, error) {
+func loadConfig(source string) (Config, error) {
 	var config Config
-	data, err := os.ReadFile(filename)
+	data, err := readConfigSource(source)
 	if err != nil {
-		return config, fmt.Errorf("failed to read config file %s: %v", filename, err)
+		return config, err
 	}
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return config, fmt.Errorf("failed to parse config file %s: %v", filename, err)
+		return config, fmt.Errorf("failed to parse config %s: %v", source, err)
+	}
+	if err := validateConfig(config); err != nil {
+		return Config{}, fmt.Errorf("invalid config %s: %v", source, err)
 	}
 	return config, nil
-}
\ No newline at end of file
+}
+
+// validateConfig catches settings that would otherwise only fail once a
+// request uses them.
+func validateConfig(config Config) error {
+	if f := config.Server.DefaultFormat; f != "" {
+		if _, ok := formatNames[f]; !ok {
+			return fmt.Errorf("unsupported server.default_format %q", f)
+		}
+	}
+	if a := config.Server.DefaultAccept; a != "" {
+		if _, ok := formatFromAccept(a); !ok {
+			return fmt.Errorf("server.default_accept %q names no supported media type", a)
+		}
+	}
+	if _, err := stripCookies(nil, config.Server.StripCookies); err != nil {
+		return err
+	}
+	if _, err := newAuthScorer(config); err != nil {
+		return err
+	}
+	if config.Chrome.ProfileLock && !profileLockSupported {
+		return fmt.Errorf("chrome.profile_lock isn't supported on %s", runtime.GOOS)
+	}
+	if err := validatePollTiming(config); err != nil {
+		return err
+	}
+	if config.Chrome.MaxProfileCookies < 0 {
+		return fmt.Errorf("chrome.max_profile_cookies must not be negative")
+	}
+	if limits := config.Chrome.ResourceLimits; limits.MaxOldSpaceMB < 0 || limits.RendererProcessLimit < 0 {
+		return fmt.Errorf("chrome.resource_limits must not be negative")
+	}
+	if c := config.Chrome; c.ClientCert != "" || c.ClientKey != "" {
+		if _, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey); err != nil {
+			return fmt.Errorf("invalid chrome.client_cert/client_key: %v", err)
+		}
+	}
+	if path := config.Server.SigningKey; path != "" {
+		if _, err := loadSigningKey(path); err != nil {
+			return err
+		}
+	}
+	if u := config.Server.RedisURL; u != "" {
+		if _, err := redis.ParseURL(u); err != nil {
+			return fmt.Errorf("invalid server.redis_url: %v", err)
+		}
+	}
+	if _, err := parseTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return err
+	}
+	if remote := config.Chrome.RemoteWSURL; remote != "" {
+		u, err := url.Parse(remote)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("invalid chrome.remote_ws_url %q: expected a ws:// or wss:// URL", remote)
+		}
+	}
+	return nil
+}
+
+// readConfigSource returns the raw config bytes from a file path, stdin
+// ("-"), or an http(s) URL.
+func readConfigSource(source string) ([]byte, error) {
+	switch {
+	case source == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from stdin: %v", err)
+		}
+		return data, nil
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		httpClient := &http.Client{Timeout: configFetchTimeout}
+		resp, err := httpClient.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch config from %s: %v", source, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch config from %s: unexpected status %s", source, resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from %s: %v", source, err)
+		}
+		return data, nil
+	default:
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", source, err)
+		}
+		return data, nil
+	}
+}
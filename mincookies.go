@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+const (
+	maxWaitMinCookies  = 1000
+	minCookiesTimeout  = 30 * time.Second
+	minCookiesInterval = 250 * time.Millisecond
+)
+
+func validateWaitMinCookies(n int, pattern string) error {
+	if n < 0 || n > maxWaitMinCookies {
+		return fmt.Errorf("wait_min_cookies must be between 0 and %d", maxWaitMinCookies)
+	}
+	if pattern == "" {
+		return nil
+	}
+	if n == 0 {
+		return fmt.Errorf("wait_min_cookies_pattern requires wait_min_cookies")
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid wait_min_cookies_pattern: %v", err)
+	}
+	return nil
+}
+
+// waitForMinCookies polls the page's cookies until at least n of them, or
+// of those whose name matches pattern when given, are set.
+func waitForMinCookies(ctx context.Context, n int, pattern string, timeout time.Duration) error {
+	var re *regexp.Regexp
+	if pattern != "" {
+		re = regexp.MustCompile(pattern)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		cookies, err := network.GetCookies().Do(ctx)
+		if err != nil {
+			return err
+		}
+		count := 0
+		for _, c := range cookies {
+			if re == nil || re.MatchString(c.Name) {
+				count++
+			}
+		}
+		if count >= n {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w waiting for %d cookies after %v, %d present", errWaitTimeout, n, timeout, count)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(minCookiesInterval):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+)
+
+// maxNavBody caps the size of nav_body.
+const maxNavBody = 1 << 20
+
+const defaultNavContentType = "application/x-www-form-urlencoded"
+
+// navContentTypes are the nav_content_type values accepted, as a browser
+// form or fetch() would send them.
+var navContentTypes = map[string]bool{
+	"application/x-www-form-urlencoded": true,
+	"application/json":                  true,
+	"text/plain":                        true,
+}
+
+func validateNavigation(payload RequestPayload, config Config) error {
+	switch payload.NavMethod {
+	case "", "GET":
+		if payload.NavBody != "" || payload.NavContentType != "" {
+			return fmt.Errorf("nav_body and nav_content_type require nav_method POST")
+		}
+		return nil
+	case "POST":
+	default:
+		return fmt.Errorf("unsupported nav_method %q: expected GET or POST", payload.NavMethod)
+	}
+	if config.Chrome.ClientCert != "" {
+		return fmt.Errorf("nav_method POST can't be combined with chrome.client_cert")
+	}
+	if len(payload.NavBody) > maxNavBody {
+		return fmt.Errorf("nav_body must not exceed %d bytes", maxNavBody)
+	}
+	contentType := payload.NavContentType
+	if contentType == "" {
+		contentType = defaultNavContentType
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !navContentTypes[mediaType] {
+		return fmt.Errorf("unsupported nav_content_type %q: expected application/x-www-form-urlencoded, application/json or text/plain", contentType)
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if _, err := url.ParseQuery(payload.NavBody); err != nil {
+			return fmt.Errorf("invalid nav_body for %s: %v", mediaType, err)
+		}
+	case "application/json":
+		if !json.Valid([]byte(payload.NavBody)) {
+			return fmt.Errorf("invalid nav_body: not valid JSON")
+		}
+	}
+	return nil
+}
+
+// navPost turns the navigation to the target into a POST through the Fetch
+// domain (see enableInterception): once armed, the next document request is
+// continued with the method, body and content type swapped in. Redirects
+// that follow are left to Chrome, which turns them into GETs as browsers do.
+type navPost struct {
+	body        string
+	contentType string
+
+	mu    sync.Mutex
+	armed bool
+}
+
+// newNavPost returns nil unless nav_method is POST.
+func newNavPost(payload RequestPayload) *navPost {
+	if payload.NavMethod != "POST" {
+		return nil
+	}
+	contentType := payload.NavContentType
+	if contentType == "" {
+		contentType = defaultNavContentType
+	}
+	return &navPost{body: payload.NavBody, contentType: contentType}
+}
+
+// arm makes the next document request the POST. It's called right before
+// navigating, so a warmup_url visit stays a GET.
+func (p *navPost) arm() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.armed = true
+	p.mu.Unlock()
+}
+
+// takes reports whether ev is the request to send as the POST, disarming it.
+func (p *navPost) takes(ev *fetch.EventRequestPaused) bool {
+	if p == nil || ev.ResourceType != network.ResourceTypeDocument {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.armed {
+		return false
+	}
+	p.armed = false
+	return true
+}
+
+func (p *navPost) continueRequest(ev *fetch.EventRequestPaused) *fetch.ContinueRequestParams {
+	headers := []*fetch.HeaderEntry{{Name: "Content-Type", Value: p.contentType}}
+	for name, value := range ev.Request.Headers {
+		if s, ok := value.(string); ok && !strings.EqualFold(name, "Content-Type") {
+			headers = append(headers, &fetch.HeaderEntry{Name: name, Value: s})
+		}
+	}
+	if verbose {
+		log.Printf("Sending the navigation to %s as a POST of %d bytes", ev.Request.URL, len(p.body))
+	}
+	return fetch.ContinueRequest(ev.RequestID).
+		WithMethod("POST").
+		WithPostData(base64.StdEncoding.EncodeToString([]byte(p.body))).
+		WithHeaders(headers)
+}
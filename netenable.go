@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// networkEnabled records the targets whose Network domain is already
+// enabled. Extra headers, resource waits and every network idle wait (warmup,
+// main, retries) need it, but one Network.enable per target is enough, and
+// repeating it on a reused browser only races with the events already
+// flowing.
+var networkEnabled = struct {
+	sync.Mutex
+	targets map[*chromedp.Target]bool
+}{targets: make(map[*chromedp.Target]bool)}
+
+// enableNetwork enables the Network domain for the target in ctx unless
+// that was already done. The record is dropped once ctx is done, so a later
+// fetch on the same target enables it again.
+func enableNetwork(ctx context.Context) error {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Target == nil {
+		if err := chromedp.Run(ctx, network.Enable()); err != nil {
+			return fmt.Errorf("failed to enable network events: %v", err)
+		}
+		return nil
+	}
+	target := c.Target
+
+	networkEnabled.Lock()
+	enabled := networkEnabled.targets[target]
+	networkEnabled.Unlock()
+	if enabled {
+		return nil
+	}
+
+	if err := network.Enable().Do(ctx); err != nil {
+		return fmt.Errorf("failed to enable network events: %v", err)
+	}
+
+	networkEnabled.Lock()
+	defer networkEnabled.Unlock()
+	if !networkEnabled.targets[target] {
+		networkEnabled.targets[target] = true
+		go func() {
+			<-ctx.Done()
+			networkEnabled.Lock()
+			delete(networkEnabled.targets, target)
+			networkEnabled.Unlock()
+		}()
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n" +
+	"# https://curl.se/docs/http-cookies.html\n" +
+	"# This file was generated by cookieapi.\n\n"
+
+// writeNetscape writes cookies in the Netscape cookie-jar format used by
+// curl's --cookie-jar and wget's --load-cookies. Each line carries seven
+// tab-separated fields: domain, include-subdomains, path, secure, expiry
+// (Unix seconds, 0 for session cookies), name and value. HttpOnly cookies have
+// their domain prefixed with "#HttpOnly_", which both tools understand even
+// though it looks like a comment.
+func writeNetscape(w io.Writer, cookies []Cookie) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(netscapeHeader)
+	for _, c := range cookies {
+		domain := c.Domain
+		includeSubdomains := !c.HostOnly
+		// curl always writes domain cookies with a leading dot.
+		if includeSubdomains && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		if c.HTTPOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if c.Expires > 0 {
+			expires = int64(c.Expires)
+		}
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(includeSubdomains), path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return bw.Flush()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// Summary aggregates a cookie set for hygiene dashboards without exposing
+// any cookie values.
+type Summary struct {
+	URL        string         `json:"url"`
+	Total      int            `json:"total"`
+	ByDomain   map[string]int `json:"by_domain"`
+	Secure     int            `json:"secure"`
+	HTTPOnly   int            `json:"http_only"`
+	Session    int            `json:"session"`
+	TotalBytes int            `json:"total_bytes"`
+}
+
+// summarizeCookies counts cookies per domain and per attribute. TotalBytes
+// is the combined length of names and values, which is what counts against
+// browser cookie size limits.
+func summarizeCookies(url string, cookies []Cookie) Summary {
+	summary := Summary{URL: url, Total: len(cookies), ByDomain: make(map[string]int)}
+	for _, c := range cookies {
+		summary.ByDomain[c.Domain]++
+		if c.Secure {
+			summary.Secure++
+		}
+		if c.HTTPOnly {
+			summary.HTTPOnly++
+		}
+		if c.Expires <= 0 {
+			summary.Session++
+		}
+		summary.TotalBytes += len(c.Name) + len(c.Value)
+	}
+	return summary
+}
+
+// cookieHeader joins cookies into a Cookie request header value.
+func cookieHeader(cookies []Cookie) string {
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// encodeBinaryValues base64-encodes, in place, the values that aren't
+// printable UTF-8 and marks them with Encoding so clients can decode them.
+func encodeBinaryValues(cookies []Cookie) {
+	for i, c := range cookies {
+		if isPrintable(c.Value) {
+			continue
+		}
+		cookies[i].Value = base64.StdEncoding.EncodeToString([]byte(c.Value))
+		cookies[i].Encoding = "base64"
+	}
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// csvHeader names the columns written by writeCSV.
+var csvHeader = []string{"name", "value", "domain", "path", "secure", "httpOnly", "sameSite", "expires"}
+
+// writeCSV writes one row per cookie for spreadsheets. expires is the Unix
+// time in seconds, or empty for session cookies.
+func writeCSV(w io.Writer, cookies []Cookie) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, c := range cookies {
+		expires := ""
+		if c.Expires > 0 {
+			expires = strconv.FormatInt(int64(c.Expires), 10)
+		}
+		cw.Write([]string{
+			c.Name,
+			c.Value,
+			c.Domain,
+			c.Path,
+			strconv.FormatBool(c.Secure),
+			strconv.FormatBool(c.HTTPOnly),
+			c.SameSite,
+			expires,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// StorageState mirrors Playwright's storageState file so the output can be
+// passed to browser.newContext({storageState}) directly.
+type StorageState struct {
+	Cookies []StorageStateCookie `json:"cookies"`
+	Origins []interface{}        `json:"origins"`
+}
+
+type StorageStateCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"`
+	HTTPOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+	SameSite string  `json:"sameSite"`
+}
+
+func newStorageState(cookies []Cookie) StorageState {
+	state := StorageState{Cookies: []StorageStateCookie{}, Origins: []interface{}{}}
+	for _, c := range cookies {
+		// Playwright rejects an empty sameSite; Lax is what browsers apply
+		// when the attribute is missing.
+		sameSite := c.SameSite
+		if sameSite == "" {
+			sameSite = "Lax"
+		}
+		state.Cookies = append(state.Cookies, StorageStateCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			HTTPOnly: c.HTTPOnly,
+			Secure:   c.Secure,
+			SameSite: sameSite,
+		})
+	}
+	return state
+}
+
+// ExtensionCookie is the cookie import format shared by the EditThisCookie
+// and Cookie-Editor browser extensions.
+type ExtensionCookie struct {
+	Name           string   `json:"name"`
+	Value          string   `json:"value"`
+	Domain         string   `json:"domain"`
+	Path           string   `json:"path"`
+	Secure         bool     `json:"secure"`
+	HTTPOnly       bool     `json:"httpOnly"`
+	HostOnly       bool     `json:"hostOnly"`
+	Session        bool     `json:"session"`
+	ExpirationDate *float64 `json:"expirationDate,omitempty"`
+	SameSite       string   `json:"sameSite"`
+}
+
+// extensionSameSite maps CDP's SameSite values to the extensions' names.
+var extensionSameSite = map[string]string{
+	"None":   "no_restriction",
+	"Lax":    "lax",
+	"Strict": "strict",
+}
+
+func newExtensionCookies(cookies []Cookie) []ExtensionCookie {
+	out := make([]ExtensionCookie, 0, len(cookies))
+	for _, c := range cookies {
+		ec := ExtensionCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			HostOnly: c.HostOnly,
+			Session:  c.Expires <= 0,
+			SameSite: "unspecified",
+		}
+		if !ec.Session {
+			expires := c.Expires
+			ec.ExpirationDate = &expires
+		}
+		if s, ok := extensionSameSite[c.SameSite]; ok {
+			ec.SameSite = s
+		}
+		out = append(out, ec)
+	}
+	return out
+}
+
+// downloadFilename names the json-download attachment after the target's
+// host, keeping only characters that are safe in a filename.
+func downloadFilename(target string) string {
+	host := "cookies"
+	if u, err := url.Parse(target); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, host)
+	return "cookies-" + safe + ".json"
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// jarRecords returns the cookie lines of a Netscape cookie jar, skipping
+// comments and blank lines but keeping #HttpOnly_ records.
+func jarRecords(jar string) []string {
+	var records []string
+	for _, line := range strings.Split(jar, "\n") {
+		if line == "" || (strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "#HttpOnly_")) {
+			continue
+		}
+		records = append(records, line)
+	}
+	return records
+}
+
+func TestWriteNetscapeMatchesCurlJar(t *testing.T) {
+	// testdata/curl-cookies.txt is the jar curl writes for these cookies.
+	golden, err := os.ReadFile("testdata/curl-cookies.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := []Cookie{
+		// HttpOnly and secure domain cookie.
+		{Name: "session", Value: "abc123", Domain: ".example.com", Path: "/", Expires: 1893456000, Secure: true, HTTPOnly: true},
+		// Host-only session cookie, which CDP reports with expires -1.
+		{Name: "cart", Value: "42", Domain: "www.example.com", Path: "/account", Expires: -1, HostOnly: true},
+		// Domain cookie without the leading dot curl always writes.
+		{Name: "prefs", Value: "dark", Domain: "example.com", Path: "/", Expires: 0},
+		// Host-only HttpOnly cookie without a path.
+		{Name: "token", Value: "xyz", Domain: "api.example.com", Expires: 1893456000, Secure: true, HTTPOnly: true, HostOnly: true},
+	}
+
+	var buf bytes.Buffer
+	if err := writeNetscape(&buf, cookies); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "# Netscape HTTP Cookie File\n") {
+		t.Errorf("jar doesn't start with the Netscape header:\n%s", buf.String())
+	}
+	got, want := jarRecords(buf.String()), jarRecords(string(golden))
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d:\n got %q\nwant %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteNetscapeEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeNetscape(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != netscapeHeader {
+		t.Errorf("empty jar = %q, want just the header", buf.String())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	"github.com/chromedp/chromedp"
+)
+
+const pageMetaScript = `(() => {
+	const description = document.querySelector('meta[name="description"]');
+	const canonical = document.querySelector('link[rel="canonical"]');
+	return {
+		description: description ? description.content : "",
+		canonical_url: canonical ? canonical.href : ""
+	};
+})()`
+
+// capturePageInfo reads the document title, meta description and canonical
+// URL of the current page.
+func capturePageInfo(ctx context.Context) (*PageInfo, error) {
+	var info PageInfo
+	if err := chromedp.Evaluate(pageMetaScript, &info).Do(ctx); err != nil {
+		return nil, err
+	}
+	if err := chromedp.Title(&info.Title).Do(ctx); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// pageParams reads ?limit and ?offset. Both are 0 when absent; limit 0
+// means no limit.
+func pageParams(r *http.Request) (limit, offset int, err error) {
+	limit, offset = queryInt(r, "limit"), queryInt(r, "offset")
+	if limit < 0 || offset < 0 {
+		return 0, 0, fmt.Errorf("limit and offset must be non-negative integers")
+	}
+	return limit, offset, nil
+}
+
+// paged reports whether the request asked for one page of the cookie set.
+func paged(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("limit") != "" || q.Get("offset") != ""
+}
+
+// sortCookies orders cookies by domain, path and name. CDP returns them in
+// no particular order, so responses sort them to keep pages and ETags
+// stable across requests.
+func sortCookies(cookies []Cookie) {
+	sort.SliceStable(cookies, func(i, j int) bool {
+		a, b := cookies[i], cookies[j]
+		return cookieLess(a.Domain, a.Path, a.Name, b.Domain, b.Path, b.Name)
+	})
+}
+
+// sortRawCookies orders raw CDP cookies like sortCookies.
+func sortRawCookies(cookies []*network.Cookie) {
+	sort.SliceStable(cookies, func(i, j int) bool {
+		a, b := cookies[i], cookies[j]
+		return cookieLess(a.Domain, a.Path, a.Name, b.Domain, b.Path, b.Name)
+	})
+}
+
+func cookieLess(aDomain, aPath, aName, bDomain, bPath, bName string) bool {
+	if aDomain != bDomain {
+		return aDomain < bDomain
+	}
+	if aPath != bPath {
+		return aPath < bPath
+	}
+	return aName < bName
+}
+
+// paginate returns the requested page of cookies, which sortCookies has
+// already put in a stable order. It is only called for paged requests,
+// whose params validateOutput has already checked.
+func paginate(r *http.Request, cookies []Cookie) []Cookie {
+	limit, offset, _ := pageParams(r)
+	if offset >= len(cookies) {
+		return []Cookie{}
+	}
+	cookies = cookies[offset:]
+	if limit > 0 && limit < len(cookies) {
+		cookies = cookies[:limit]
+	}
+	return cookies
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultPollInterval = 100 * time.Millisecond
+	minPollInterval     = 10 * time.Millisecond
+	maxPollInterval     = time.Second
+)
+
+// pollTiming spaces the checks of the network idle and URL pattern waits.
+// Jitter spreads them around the interval so the pollers of many concurrent
+// fetches, and of one fetch's waits, don't fall into step with each other or
+// with a page's own timers.
+type pollTiming struct {
+	interval time.Duration
+	jitter   time.Duration
+}
+
+func newPollTiming(config Config) pollTiming {
+	interval := config.Chrome.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	return pollTiming{interval: interval, jitter: config.Chrome.PollJitter}
+}
+
+// next returns the wait before the next check, interval plus or minus up to
+// jitter.
+func (p pollTiming) next() time.Duration {
+	if p.jitter <= 0 {
+		return p.interval
+	}
+	return p.interval - p.jitter + time.Duration(rand.Int63n(int64(2*p.jitter)+1))
+}
+
+// validatePollTiming checks chrome.poll_interval and poll_jitter. Jitter may
+// be at most half the interval, so checks stay at least interval/2 apart.
+func validatePollTiming(config Config) error {
+	interval := config.Chrome.PollInterval
+	if interval != 0 && (interval < minPollInterval || interval > maxPollInterval) {
+		return fmt.Errorf("chrome.poll_interval must be between %v and %v", minPollInterval, maxPollInterval)
+	}
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	if jitter := config.Chrome.PollJitter; jitter < 0 || jitter > interval/2 {
+		return fmt.Errorf("chrome.poll_jitter must be between 0 and half of chrome.poll_interval (%v)", interval/2)
+	}
+	return nil
+}
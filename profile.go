@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// defaultProfileDirs are Chrome's own user data dirs per GOOS, used when
+// chrome.profile_dir is not set. Other systems fall back to the Linux one.
+var defaultProfileDirs = map[string]string{
+	"windows": "~/AppData/Local/Google/Chrome/User Data/",
+	"darwin":  "~/Library/Application Support/Google/Chrome/",
+	"linux":   "~/.config/google-chrome/",
+}
+
+func defaultProfileDir() string {
+	if dir, ok := defaultProfileDirs[runtime.GOOS]; ok {
+		return dir
+	}
+	return defaultProfileDirs["linux"]
+}
+
+// logDefaultProfileDir reports at startup which user data dir requests
+// without a configured profile_dir will use.
+func logDefaultProfileDir(config Config) {
+	if config.Chrome.ProfileDir != "" || config.Chrome.RemoteWSURL != "" {
+		return
+	}
+	dir, err := profileDir(config, "")
+	if err != nil {
+		log.Printf("Failed to resolve the default Chrome profile dir: %v", err)
+		return
+	}
+	log.Printf("chrome.profile_dir is not set, using the %s default %s", runtime.GOOS, dir)
+}
+
+// defaultProfileName lists chrome.profile_dir in GET /profiles. Requests
+// select it by omitting profile.
+const defaultProfileName = "default"
+
+// profileDir resolves the user data dir of the named profile, or of
+// chrome.profile_dir when name is empty.
+func profileDir(config Config, name string) (string, error) {
+	dir := config.Chrome.ProfileDir
+	if name != "" && name != defaultProfileName {
+		var ok bool
+		if dir, ok = config.Chrome.Profiles[name]; !ok {
+			return "", &codedError{
+				Code:   "UNKNOWN_PROFILE",
+				Status: http.StatusBadRequest,
+				Err:    fmt.Errorf("unknown profile %q", name),
+			}
+		}
+	}
+	if dir == "" {
+		dir = defaultProfileDir()
+	}
+	expanded, err := homedir.Expand(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand profile dir: %v", err)
+	}
+	return expanded, nil
+}
+
+// checkProfileDir catches a profile dir that doesn't exist, which Chrome
+// would silently create as an empty profile with no cookies. It returns a
+// warning for the result, or PROFILE_NOT_FOUND with
+// chrome.require_profile_dir.
+func checkProfileDir(dir string, config Config) (warning string, err error) {
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return "", nil
+	}
+	msg := fmt.Sprintf("profile dir %s doesn't exist, so Chrome starts with an empty profile without the cookies of any logged-in session", dir)
+	if config.Chrome.RequireProfileDir {
+		return "", &codedError{
+			Code:   "PROFILE_NOT_FOUND",
+			Status: http.StatusUnprocessableEntity,
+			Err:    errors.New(msg),
+		}
+	}
+	log.Printf("Warning: %s", msg)
+	return msg, nil
+}
+
+// activeProfiles counts the running fetches per profile dir.
+var activeProfiles = struct {
+	sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+// useProfile marks dir as in use until the returned func is called.
+func useProfile(dir string) (release func()) {
+	activeProfiles.Lock()
+	activeProfiles.count[dir]++
+	activeProfiles.Unlock()
+	return func() {
+		activeProfiles.Lock()
+		defer activeProfiles.Unlock()
+		if activeProfiles.count[dir]--; activeProfiles.count[dir] <= 0 {
+			delete(activeProfiles.count, dir)
+		}
+	}
+}
+
+func profileInUse(dir string) bool {
+	activeProfiles.Lock()
+	defer activeProfiles.Unlock()
+	return activeProfiles.count[dir] > 0
+}
+
+// ProfileInfo describes one configured profile in GET /profiles.
+type ProfileInfo struct {
+	Name   string `json:"name"`
+	Dir    string `json:"dir"`
+	Exists bool   `json:"exists"`
+	InUse  bool   `json:"in_use"`
+}
+
+// listProfiles returns the default profile followed by chrome.profiles in
+// name order.
+func listProfiles(config Config) []ProfileInfo {
+	names := make([]string, 0, len(config.Chrome.Profiles))
+	for name := range config.Chrome.Profiles {
+		if name != defaultProfileName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{defaultProfileName}, names...)
+
+	profiles := make([]ProfileInfo, 0, len(names))
+	for _, name := range names {
+		info := ProfileInfo{Name: name}
+		dir, err := profileDir(config, name)
+		if err != nil {
+			log.Printf("Skipping profile %s: %v", name, err)
+			continue
+		}
+		info.Dir = dir
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			info.Exists = true
+		}
+		info.InUse = profileInUse(dir)
+		profiles = append(profiles, info)
+	}
+	return profiles
+}
+
+// handleProfiles serves GET /profiles. Like the admin endpoints it needs
+// server.api_key, since it reveals paths on the server.
+func handleProfiles(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		sendError(w, "Only GET requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.Server.APIKey == "" {
+		sendError(w, "Listing profiles requires server.api_key to be configured", http.StatusForbidden)
+		return
+	}
+	sendJSONResponse(w, listProfiles(config))
+}
+
+// profileCopyFiles lists the files, relative to the user data dir, that
+// Chrome needs to read cookies and decrypt their values. Entries that don't
+// exist are skipped since their location differs between Chrome versions.
+var profileCopyFiles = []string{
+	"Local State",
+	"Default/Cookies",
+	"Default/Cookies-journal",
+	"Default/Network/Cookies",
+	"Default/Network/Cookies-journal",
+	"Default/Login Data",
+	"Default/Login Data-journal",
+}
+
+// copyProfile copies the cookie-related files of the profile at src into a
+// fresh temp dir and returns its path. The caller removes it with
+// tempDirs.remove when done.
+func copyProfile(src string) (string, error) {
+	dst, err := tempDirs.create("profile-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp profile dir: %v", err)
+	}
+	copied := 0
+	for _, rel := range profileCopyFiles {
+		from := filepath.Join(src, rel)
+		if _, err := os.Stat(from); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := copyFile(from, filepath.Join(dst, rel)); err != nil {
+			tempDirs.remove(dst)
+			return "", fmt.Errorf("failed to copy %s: %v", rel, err)
+		}
+		copied++
+	}
+	if verbose {
+		log.Printf("Copied %d profile files from %s to %s", copied, src, dst)
+	}
+	return dst, nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(to), 0o700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
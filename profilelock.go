@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// profileLockFile is created in the profile dir to hold the lock.
+	profileLockFile = "cookieapi.lock"
+	// defaultProfileLockTimeout is used when chrome.profile_lock_timeout
+	// is unset.
+	defaultProfileLockTimeout = 30 * time.Second
+	// profileLockPoll is the interval between attempts to take a held lock.
+	profileLockPoll = 100 * time.Millisecond
+)
+
+// lockProfile takes an exclusive advisory lock on the profile dir, so that
+// fetches of this and other server instances on the same host never run
+// Chrome against it at the same time, which would fail on Chrome's own
+// SingletonLock. It is a no-op unless chrome.profile_lock is set. The
+// returned func releases the lock, at most once however often it is
+// called, and must be called on every path. Waiting for a held lock ends
+// with ctx. The profile dir is created if missing, as Chrome would.
+func lockProfile(ctx context.Context, dir string, config Config) (unlock func(), err error) {
+	if !config.Chrome.ProfileLock {
+		return func() {}, nil
+	}
+	timeout := config.Chrome.ProfileLockTimeout
+	if timeout <= 0 {
+		timeout = defaultProfileLockTimeout
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create profile dir %s: %v", dir, err)
+	}
+	path := filepath.Join(dir, profileLockFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profile lock %s: %v", path, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for waited := false; ; waited = true {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock profile %s: %v", dir, err)
+		}
+		if ok {
+			if waited && verbose {
+				log.Printf("Acquired the lock on profile %s", dir)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &codedError{
+				Code:   "PROFILE_LOCKED",
+				Status: http.StatusConflict,
+				Err:    fmt.Errorf("profile %s is still in use by another fetch after %v", dir, timeout),
+			}
+		}
+		if !waited && verbose {
+			log.Printf("Waiting up to %v for the lock on profile %s", timeout, dir)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(profileLockPoll):
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := unlockFile(f); err != nil {
+				log.Printf("Failed to unlock profile %s: %v", dir, err)
+			}
+			f.Close()
+		})
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// profileLockSupported is false where there is neither flock nor
+// LockFileEx; validateConfig then rejects chrome.profile_lock.
+const profileLockSupported = false
+
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chromedp/cdproto/fetch"
+)
+
+var errProxyAuthRejected = errors.New("proxy rejected the configured credentials")
+
+// proxyAuth answers proxy authentication challenges raised through the Fetch
+// domain (see enableInterception). Challenges from the target site itself are
+// left to Chrome's default handling so proxy credentials are never sent to
+// the origin.
+type proxyAuth struct {
+	username string
+	password string
+
+	mu       sync.Mutex
+	answered map[fetch.RequestID]bool
+	rejected bool
+}
+
+// newProxyAuth returns nil when no proxy credentials are configured.
+func newProxyAuth(config Config) *proxyAuth {
+	proxy := config.Chrome.Proxy
+	if proxy.Server == "" || proxy.Username == "" {
+		return nil
+	}
+	return &proxyAuth{
+		username: proxy.Username,
+		password: proxy.Password,
+		answered: make(map[fetch.RequestID]bool),
+	}
+}
+
+func (a *proxyAuth) respond(ev *fetch.EventAuthRequired) *fetch.AuthChallengeResponse {
+	if ev.AuthChallenge == nil || ev.AuthChallenge.Source != fetch.AuthChallengeSourceProxy {
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Chrome raises the challenge again for the same request when the proxy
+	// refuses the credentials we sent, so a second challenge means rejection.
+	if a.answered[ev.RequestID] {
+		a.rejected = true
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+	}
+	a.answered[ev.RequestID] = true
+	return &fetch.AuthChallengeResponse{
+		Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+		Username: a.username,
+		Password: a.password,
+	}
+}
+
+func (a *proxyAuth) wasRejected() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rejected
+}
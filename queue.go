@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// fetchSlots enforces server.max_concurrent. It's created once at startup
+// and nil when the option is unset.
+var fetchSlots *fetchLimiter
+
+// defaultMaxQueue caps how many requests may wait for a browser slot when
+// server.max_queue is not set.
+const defaultMaxQueue = 100
+
+// fetchLimiter bounds how many fetches run at once. Requests that find every
+// slot taken wait in FIFO order, up to server.queue_timeout, before being
+// turned away with a 503.
+type fetchLimiter struct {
+	slots    chan struct{}
+	maxQueue int64
+
+	inFlight atomic.Int64
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// newFetchLimiter returns nil when server.max_concurrent is unset, meaning
+// fetches are not limited.
+func newFetchLimiter(config Config) *fetchLimiter {
+	if config.Server.MaxConcurrent <= 0 {
+		return nil
+	}
+	maxQueue := config.Server.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = defaultMaxQueue
+	}
+	return &fetchLimiter{
+		slots:    make(chan struct{}, config.Server.MaxConcurrent),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+// acquire takes a slot, waiting up to wait for one to free up. It reports
+// false when the queue is full, the wait ran out or the client went away.
+func (l *fetchLimiter) acquire(ctx context.Context, wait time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Add(1)
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	// Blocked channel sends are woken in arrival order, which is what keeps
+	// the queue FIFO.
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Add(1)
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *fetchLimiter) release() {
+	l.inFlight.Add(-1)
+	<-l.slots
+}
+
+// take holds a slot for one fetch, queueing for up to server.queue_timeout.
+// Each URL of a batch takes its own, so batch_concurrency doesn't get past
+// server.max_concurrent. A nil limiter admits every fetch.
+func (l *fetchLimiter) take(ctx context.Context, config Config) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	wait := config.Server.QueueTimeout
+	if !l.acquire(ctx, wait) {
+		l.rejected.Add(1)
+		return nil, &codedError{
+			Code:       "SERVER_BUSY",
+			Status:     http.StatusServiceUnavailable,
+			Err:        fmt.Errorf("server is busy, no browser slot free after %s, retry later", wait),
+			RetryAfter: retryAfterSeconds(wait),
+		}
+	}
+	return l.release, nil
+}
+
+// requestGate counts the fetch requests being handled, whether running or
+// queued for a browser slot, and turns new ones away beyond
+// server.max_inflight. It is the hard cap in front of the fetchLimiter.
+type requestGate struct {
+	inFlight atomic.Int64
+	rejected atomic.Int64
+}
+
+func (g *requestGate) admit(next http.HandlerFunc, store *configStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := store.Load()
+		n := g.inFlight.Add(1)
+		defer g.inFlight.Add(-1)
+		if max := config.Server.MaxInflight; max > 0 && n > int64(max) {
+			g.rejected.Add(1)
+			if verbose {
+				log.Printf("Rejecting %s from %s: %d requests already in flight", r.URL.Path, clientIP(r, config), max)
+			}
+			w.Header().Set("Retry-After", "1")
+			sendError(w, "Server is overloaded, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// retryAfterSeconds suggests waiting about as long as a request may queue,
+// and at least a second.
+func retryAfterSeconds(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
+
+// handleMetrics serves the gate's and the limiter's gauges in the Prometheus
+// text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request, g *requestGate, l *fetchLimiter) {
+	var inFlight, queued, rejected int64
+	if l != nil {
+		inFlight, queued, rejected = l.inFlight.Load(), l.queued.Load(), l.rejected.Load()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP cookieapi_requests_in_flight Fetch requests being handled, running or queued.\n")
+	fmt.Fprintf(w, "# TYPE cookieapi_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "cookieapi_requests_in_flight %d\n", g.inFlight.Load())
+	fmt.Fprintf(w, "# HELP cookieapi_requests_overloaded_total Requests turned away because server.max_inflight was reached.\n")
+	fmt.Fprintf(w, "# TYPE cookieapi_requests_overloaded_total counter\n")
+	fmt.Fprintf(w, "cookieapi_requests_overloaded_total %d\n", g.rejected.Load())
+	fmt.Fprintf(w, "# HELP cookieapi_fetches_in_flight Fetches currently holding a browser slot.\n")
+	fmt.Fprintf(w, "# TYPE cookieapi_fetches_in_flight gauge\n")
+	fmt.Fprintf(w, "cookieapi_fetches_in_flight %d\n", inFlight)
+	fmt.Fprintf(w, "# HELP cookieapi_queue_depth Requests waiting for a browser slot.\n")
+	fmt.Fprintf(w, "# TYPE cookieapi_queue_depth gauge\n")
+	fmt.Fprintf(w, "cookieapi_queue_depth %d\n", queued)
+	fmt.Fprintf(w, "# HELP cookieapi_requests_rejected_total Requests turned away because no slot freed up in time.\n")
+	fmt.Fprintf(w, "# TYPE cookieapi_requests_rejected_total counter\n")
+	fmt.Fprintf(w, "cookieapi_requests_rejected_total %d\n", rejected)
+}
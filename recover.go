@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+)
+
+// requestIDRe limits client-supplied request IDs to what is safe to log and
+// echo back.
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID returns the client's X-Request-ID, or a new random one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); requestIDRe.MatchString(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// recoverPanics turns a panic in next into a 500 with the INTERNAL_PANIC code
+// instead of a crashed server, logging the stack under the request's ID,
+// which is also sent back in X-Request-ID. Panics in goroutines of their
+// own, such as CDP event listeners, can't be caught here.
+func recoverPanics(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("PANIC in request %s (%s %s): %v\n%s", id, r.Method, r.URL.Path, p, debug.Stack())
+			// A response that has already started can't be turned into
+			// an error anymore.
+			if rec.status == 0 {
+				w.Header().Set("X-Error-Code", "INTERNAL_PANIC")
+				sendError(w, fmt.Sprintf("Internal error, request ID %s", id), http.StatusInternalServerError)
+			}
+		}()
+		next(rec, r)
+	}
+}
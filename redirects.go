@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const defaultMaxRedirects = 20
+
+// redirectGuard counts HTTP redirects of the main frame's document and
+// aborts the fetch once they exceed the limit, so a redirect loop fails fast
+// instead of running into the overall timeout.
+type redirectGuard struct {
+	limit int
+	abort context.CancelCauseFunc
+
+	mu    sync.Mutex
+	chain []string
+	count int
+}
+
+func newRedirectGuard(config Config, abort context.CancelCauseFunc) *redirectGuard {
+	limit := config.Chrome.MaxRedirects
+	if limit <= 0 {
+		limit = defaultMaxRedirects
+	}
+	return &redirectGuard{limit: limit, abort: abort}
+}
+
+// listen watches ctx's target. Redirects don't produce a responseReceived
+// event; each hop instead re-sends the request with the redirect response
+// attached, which is what is counted here. A request without one starts a
+// new navigation, e.g. the warmup or a retry, whose chain is counted afresh.
+func (g *redirectGuard) listen(ctx context.Context) {
+	mainFrame := cdp.FrameID(chromedp.FromContext(ctx).Target.TargetID)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		e, ok := ev.(*network.EventRequestWillBeSent)
+		if !ok || e.Type != network.ResourceTypeDocument || e.FrameID != mainFrame {
+			return
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if e.RedirectResponse == nil {
+			g.chain, g.count = nil, 0
+			return
+		}
+		if len(g.chain) == 0 {
+			g.chain = append(g.chain, e.RedirectResponse.URL)
+		}
+		g.chain = append(g.chain, e.Request.URL)
+		g.count++
+		if g.count > g.limit {
+			g.abort(&codedError{
+				Code:   "TOO_MANY_REDIRECTS",
+				Status: http.StatusBadGateway,
+				Err: fmt.Errorf("too many redirects (more than %d): %s",
+					g.limit, strings.Join(g.chain, " -> ")),
+			})
+		}
+	})
+}
+
+// enable turns on the Network domain the guard's events come from. It must
+// run before anything navigates.
+func (g *redirectGuard) enable() chromedp.Action {
+	return chromedp.ActionFunc(enableNetwork)
+}
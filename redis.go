@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisKeyPrefix = "cookieapi:cookies:"
+	// sessionRedisTTL expires keys holding only session cookies, which
+	// have no expiry of their own.
+	sessionRedisTTL   = 24 * time.Hour
+	redisWriteTimeout = 5 * time.Second
+)
+
+// redisSink exports the cookies of every fetch to Redis when
+// server.redis_url is set. It's created once at startup.
+var redisSink *cookieSink
+
+type cookieSink struct {
+	client *redis.Client
+	prefix string
+}
+
+// newCookieSink returns nil when no server.redis_url is configured.
+func newCookieSink(config Config) (*cookieSink, error) {
+	if config.Server.RedisURL == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(config.Server.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server.redis_url: %v", err)
+	}
+	prefix := config.Server.RedisKeyPrefix
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	return &cookieSink{client: redis.NewClient(opts), prefix: prefix}, nil
+}
+
+// export stores cookies under a key derived from url and returns the key.
+// The write happens in the background and failures are only logged, so a
+// Redis outage never fails a fetch. It returns "" on a nil sink.
+func (s *cookieSink) export(url string, cookies []Cookie) string {
+	if s == nil {
+		return ""
+	}
+	key := s.prefix + url
+	value, err := json.Marshal(cookies)
+	if err != nil {
+		log.Printf("Failed to encode cookies for Redis key %s: %v", key, err)
+		return ""
+	}
+	ttl := redisTTL(cookies, time.Now())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisWriteTimeout)
+		defer cancel()
+		if err := s.client.Set(ctx, key, value, ttl).Err(); err != nil {
+			log.Printf("Failed to write cookies to Redis key %s: %v", key, err)
+		} else if verbose {
+			log.Printf("Wrote %d cookies to Redis key %s with a TTL of %v", len(cookies), key, ttl)
+		}
+	}()
+	return key
+}
+
+// redisTTL is the time until the first of the cookies expires, so the key
+// never outlives its shortest-lived cookie.
+func redisTTL(cookies []Cookie, now time.Time) time.Duration {
+	var ttl time.Duration
+	for _, c := range cookies {
+		if c.Expires <= 0 {
+			continue
+		}
+		left := time.Unix(int64(c.Expires), 0).Sub(now)
+		if ttl == 0 || left < ttl {
+			ttl = left
+		}
+	}
+	switch {
+	case ttl == 0:
+		return sessionRedisTTL
+	case ttl < time.Second:
+		return time.Second
+	}
+	return ttl
+}
+
+// setRedisKeyHeader reports the Redis key in the X-Redis-Key header, for
+// formats that have no envelope to carry it.
+func setRedisKeyHeader(w http.ResponseWriter, key string) {
+	if key != "" {
+		w.Header().Set("X-Redis-Key", key)
+	}
+}
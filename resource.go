@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// resourceTimeout bounds the wait for wait_resource.
+const resourceTimeout = 30 * time.Second
+
+// resourceWatcher notices when a request whose URL contains a substring has
+// finished loading. Like eventWatcher it is started before navigation, so a
+// resource loaded early in the page load counts too.
+type resourceWatcher struct {
+	substr string
+	loaded chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	pending map[network.RequestID]string
+}
+
+func watchForResource(ctx context.Context, substr string) (*resourceWatcher, error) {
+	w := &resourceWatcher{
+		substr:  substr,
+		loaded:  make(chan struct{}),
+		pending: make(map[network.RequestID]string),
+	}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			if strings.Contains(ev.Request.URL, substr) {
+				w.mu.Lock()
+				w.pending[ev.RequestID] = ev.Request.URL
+				w.mu.Unlock()
+			}
+		case *network.EventLoadingFinished:
+			w.mu.Lock()
+			url, ok := w.pending[ev.RequestID]
+			w.mu.Unlock()
+			if !ok {
+				return
+			}
+			w.once.Do(func() {
+				if verbose {
+					log.Printf("Resource %s finished loading", url)
+				}
+				close(w.loaded)
+			})
+		}
+	})
+	if err := enableNetwork(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// wait returns once a matching resource has loaded.
+func (w *resourceWatcher) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(resourceTimeout):
+		w.mu.Lock()
+		started := len(w.pending)
+		w.mu.Unlock()
+		if started == 0 {
+			return fmt.Errorf("%w: no request for a resource containing %q within %v", errWaitTimeout, w.substr, resourceTimeout)
+		}
+		return fmt.Errorf("%w: %d request(s) for a resource containing %q didn't finish loading within %v", errWaitTimeout, started, w.substr, resourceTimeout)
+	case <-w.loaded:
+		return nil
+	}
+}
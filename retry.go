@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelayMS  = 1000
+	maxRetryAttempts     = 10
+	maxRetryDelayMS      = 30000
+)
+
+func validateRetry(o RetryOptions) error {
+	if o.MaxAttempts < 0 || o.MaxAttempts > maxRetryAttempts {
+		return fmt.Errorf("retry_if_empty.max_attempts must be between 0 and %d", maxRetryAttempts)
+	}
+	if o.DelayMS < 0 || o.DelayMS > maxRetryDelayMS {
+		return fmt.Errorf("retry_if_empty.delay_ms must be between 0 and %d", maxRetryDelayMS)
+	}
+	for _, name := range o.Require {
+		if name == "" {
+			return fmt.Errorf("retry_if_empty.require must not contain empty names")
+		}
+	}
+	return nil
+}
+
+func retryDefaults(o RetryOptions) RetryOptions {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = defaultRetryAttempts
+	}
+	if o.DelayMS == 0 {
+		o.DelayMS = defaultRetryDelayMS
+	}
+	return o
+}
+
+// retrySatisfied reports whether cookies are worth returning without a retry.
+func retrySatisfied(o RetryOptions, cookies []Cookie) bool {
+	if len(o.Require) == 0 {
+		return len(cookies) > 0
+	}
+	present := make(map[string]bool, len(cookies))
+	for _, c := range cookies {
+		present[c.Name] = true
+	}
+	for _, name := range o.Require {
+		if !present[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// retryFetch calls refetch, which navigates again and collects the
+// cookies, until select returns cookies from raw that satisfy opts or the
+// attempts run out. raw holds the first attempt's cookies. It returns the
+// cookies of the last attempt and the number of attempts made.
+func retryFetch(ctx context.Context, opts RetryOptions, raw []*network.Cookie,
+	selectCookies func([]*network.Cookie) ([]Cookie, error),
+	refetch func(context.Context) ([]*network.Cookie, error)) ([]*network.Cookie, int, error) {
+	opts = retryDefaults(opts)
+	delay := time.Duration(opts.DelayMS) * time.Millisecond
+	attempt := 1
+	for ; attempt < opts.MaxAttempts; attempt++ {
+		cookies, err := selectCookies(raw)
+		if err != nil {
+			return nil, attempt, err
+		}
+		if retrySatisfied(opts, cookies) {
+			break
+		}
+		if verbose {
+			log.Printf("Attempt %d collected %d matching cookies, retrying in %v", attempt, len(cookies), delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, attempt, ctx.Err()
+		case <-time.After(delay):
+		}
+		if raw, err = refetch(ctx); err != nil {
+			return nil, attempt, fmt.Errorf("retry %d failed: %v", attempt, err)
+		}
+	}
+	return raw, attempt, nil
+}
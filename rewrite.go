@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// domainRewrite maps a cookie domain, and the domains below it, to another.
+type domainRewrite struct {
+	from, to string
+}
+
+// parseRewriteDomain parses ?rewrite_domain=from:to,from2:to2.
+func parseRewriteDomain(v string) ([]domainRewrite, error) {
+	var rewrites []domainRewrite
+	for _, pair := range strings.Split(v, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		from = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(from), "."))
+		to = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(to), "."))
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid rewrite_domain %q: expected from:to pairs such as prod.example.com:test.example.com", v)
+		}
+		rewrites = append(rewrites, domainRewrite{from: from, to: to})
+	}
+	return rewrites, nil
+}
+
+// rewrite returns domain with a from suffix replaced by to, keeping the
+// leading dot of domain cookies and leaving host-only ones without it. It
+// only matches whole labels, so example.com doesn't rewrite myexample.com.
+func (d domainRewrite) rewrite(domain string) (string, bool) {
+	dot := ""
+	if strings.HasPrefix(domain, ".") {
+		dot, domain = ".", domain[1:]
+	}
+	lower := strings.ToLower(domain)
+	switch {
+	case lower == d.from:
+		return dot + d.to, true
+	case strings.HasSuffix(lower, "."+d.from):
+		return dot + domain[:len(domain)-len(d.from)] + d.to, true
+	}
+	return "", false
+}
+
+// rewriteDomains applies ?rewrite_domain to the exported cookies, using the
+// first rewrite that matches each one. The browser's cookies are left
+// alone.
+func rewriteDomains(r *http.Request, cookies []Cookie) {
+	v := r.URL.Query().Get("rewrite_domain")
+	if v == "" {
+		return
+	}
+	rewrites, err := parseRewriteDomain(v)
+	if err != nil {
+		return
+	}
+	for i := range cookies {
+		for _, rw := range rewrites {
+			if domain, ok := rw.rewrite(cookies[i].Domain); ok {
+				cookies[i].Domain = domain
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	defaultMaxScrolls    = 10
+	defaultScrollDelayMS = 500
+	maxScrollsLimit      = 100
+	maxScrollDelayMS     = 10000
+)
+
+func validateScroll(o ScrollOptions) error {
+	if o.MaxScrolls < 0 || o.MaxScrolls > maxScrollsLimit {
+		return fmt.Errorf("scroll.max_scrolls must be between 0 and %d", maxScrollsLimit)
+	}
+	if o.StepDelayMS < 0 || o.StepDelayMS > maxScrollDelayMS {
+		return fmt.Errorf("scroll.step_delay_ms must be between 0 and %d", maxScrollDelayMS)
+	}
+	return nil
+}
+
+func scrollDefaults(o ScrollOptions) ScrollOptions {
+	if o.MaxScrolls == 0 {
+		o.MaxScrolls = defaultMaxScrolls
+	}
+	if o.StepDelayMS == 0 {
+		o.StepDelayMS = defaultScrollDelayMS
+	}
+	return o
+}
+
+// scrollPage scrolls to the bottom until the document height stabilizes or
+// MaxScrolls is reached.
+func scrollPage(ctx context.Context, opts ScrollOptions) error {
+	opts = scrollDefaults(opts)
+	delay := time.Duration(opts.StepDelayMS) * time.Millisecond
+
+	var height float64
+	if err := chromedp.Evaluate(`document.documentElement.scrollHeight`, &height).Do(ctx); err != nil {
+		return err
+	}
+	for i := 1; i <= opts.MaxScrolls; i++ {
+		if err := chromedp.Evaluate(`window.scrollTo(0, document.documentElement.scrollHeight)`, nil).Do(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		var newHeight float64
+		if err := chromedp.Evaluate(`document.documentElement.scrollHeight`, &newHeight).Do(ctx); err != nil {
+			return err
+		}
+		if newHeight == height {
+			if verbose {
+				log.Printf("Page height stable at %.0fpx after %d scrolls", height, i)
+			}
+			return nil
+		}
+		height = newHeight
+	}
+	if verbose {
+		log.Printf("Stopped scrolling after %d scrolls at %.0fpx", opts.MaxScrolls, height)
+	}
+	return nil
+}
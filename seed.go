@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+)
+
+// maxSeedCookies caps how many cookies a request may seed.
+const maxSeedCookies = 500
+
+var seedSameSite = map[string]network.CookieSameSite{
+	"":       "",
+	"Strict": network.CookieSameSiteStrict,
+	"Lax":    network.CookieSameSiteLax,
+	"None":   network.CookieSameSiteNone,
+}
+
+func validateSeedCookies(cookies []Cookie) error {
+	if len(cookies) > maxSeedCookies {
+		return fmt.Errorf("at most %d seed_cookies are allowed", maxSeedCookies)
+	}
+	for i, c := range cookies {
+		if c.Name == "" {
+			return fmt.Errorf("seed_cookies[%d] has no name", i)
+		}
+		if _, ok := seedSameSite[c.SameSite]; !ok {
+			return fmt.Errorf("seed_cookies[%d] has invalid same_site %q: expected Strict, Lax or None", i, c.SameSite)
+		}
+		switch c.Encoding {
+		case "":
+		case "base64":
+			if _, err := base64.StdEncoding.DecodeString(c.Value); err != nil {
+				return fmt.Errorf("seed_cookies[%d] has an invalid base64 value: %v", i, err)
+			}
+		default:
+			return fmt.Errorf("seed_cookies[%d] has unsupported encoding %q", i, c.Encoding)
+		}
+	}
+	return nil
+}
+
+// seedCookieParam converts a cookie in our own output shape, such as one
+// returned by an earlier fetch, into the form needed to set it. Cookies
+// without a domain are scoped to the host of target.
+func seedCookieParam(c Cookie, target string) *network.CookieParam {
+	value := c.Value
+	if c.Encoding == "base64" {
+		decoded, _ := base64.StdEncoding.DecodeString(value)
+		value = string(decoded)
+	}
+	p := &network.CookieParam{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HTTPOnly: c.HTTPOnly,
+		SameSite: seedSameSite[c.SameSite],
+	}
+	switch {
+	case c.Domain == "":
+		u, _ := url.Parse(target)
+		p.URL = u.Scheme + "://" + u.Host + "/"
+		if p.Path == "" {
+			p.Path = "/"
+		}
+	case c.HostOnly || isHostOnly(c.Domain):
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		p.URL = scheme + "://" + c.Domain + c.Path
+	default:
+		p.Domain = strings.TrimPrefix(c.Domain, ".")
+	}
+	if c.Expires > 0 {
+		expires := cdp.TimeSinceEpoch(time.Unix(0, int64(c.Expires*float64(time.Second))))
+		p.Expires = &expires
+	}
+	return p
+}
+
+// seedCookies sets the given cookies in the browser, replacing any profile
+// cookie with the same name, domain and path.
+func seedCookies(ctx context.Context, cookies []Cookie, target string) error {
+	params := make([]*network.CookieParam, len(cookies))
+	for i, c := range cookies {
+		params[i] = seedCookieParam(c, target)
+	}
+	if err := network.SetCookies(params).Do(ctx); err != nil {
+		return fmt.Errorf("failed to set seed_cookies: %v", err)
+	}
+	if verbose {
+		log.Printf("Seeded %d cookies", len(cookies))
+	}
+	return nil
+}
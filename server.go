@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests may run after a
+// shutdown signal.
+const shutdownTimeout = 10 * time.Second
+
+// unixSocketMode lets the owner and group talk to the server socket.
+const unixSocketMode = 0o660
+
+// listen opens the server's listener: the Unix socket at server.unix_socket
+// when configured, otherwise TCP on server.ip and server.port.
+func listen(config Config) (net.Listener, error) {
+	if path := config.Server.UnixSocket; path != "" {
+		return listenUnix(path)
+	}
+
+	serverIP := config.Server.IP
+	if serverIP == "" {
+		serverIP = "0.0.0.0"
+	}
+	serverPort := config.Server.Port
+	if serverPort == 0 {
+		serverPort = 8080
+	}
+	return net.Listen("tcp", fmt.Sprintf("%s:%d", serverIP, serverPort))
+}
+
+// listenUnix listens on a Unix socket, first removing a socket left behind
+// by an unclean exit. The listener unlinks the socket file when closed, which
+// happens on graceful shutdown.
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSocket != 0:
+		if verbose {
+			log.Printf("Removing stale socket %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", path, err)
+		}
+	case err == nil:
+		return nil, fmt.Errorf("refusing to replace %s: not a socket", path)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("failed to stat socket %s: %v", path, err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions on socket %s: %v", path, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// loadSigningKey reads the PKCS#8 PEM Ed25519 private key at path, as
+// written by "openssl genpkey -algorithm ed25519".
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server.signing_key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("server.signing_key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server.signing_key: %v", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("server.signing_key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// bufferedResponse collects a response so it can be signed before any of it
+// is sent.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+
+// writeSigned runs write against a buffer and sends the result with the
+// Ed25519 signature of the exact body bytes, base64-encoded, in X-Signature.
+// Without server.signing_key it writes straight through.
+func writeSigned(w http.ResponseWriter, config Config, write func(http.ResponseWriter)) {
+	if config.Server.SigningKey == "" {
+		write(w)
+		return
+	}
+	buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+	write(buf)
+	sig, err := signBody(config, buf.body.Bytes())
+	if err != nil {
+		log.Printf("Failed to sign response: %v", err)
+		sendError(w, "Failed to sign response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Signature", sig)
+	w.WriteHeader(buf.status)
+	w.Write(buf.body.Bytes())
+}
+
+// signBody returns the base64 Ed25519 signature of body under
+// server.signing_key, which must be set.
+func signBody(config Config, body []byte) (string, error) {
+	key, err := loadSigningKey(config.Server.SigningKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, body)), nil
+}
+
+// handlePubkey serves the public half of server.signing_key so clients can
+// verify X-Signature.
+func handlePubkey(w http.ResponseWriter, r *http.Request, config Config) {
+	if config.Server.SigningKey == "" {
+		sendError(w, "Response signing is not configured", http.StatusNotFound)
+		return
+	}
+	key, err := loadSigningKey(config.Server.SigningKey)
+	if err != nil {
+		log.Printf("Failed to load signing key: %v", err)
+		sendError(w, "Failed to load signing key", http.StatusInternalServerError)
+		return
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		sendError(w, "Failed to encode public key", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, map[string]string{
+		"algorithm":  "ed25519",
+		"public_key": base64.StdEncoding.EncodeToString(pub),
+		"pem":        string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// targetParamPrefix marks a parameter of the path form as the target's own,
+// so /fetch-cookies/site.com/list?target.limit=5&limit=10 targets
+// site.com/list?limit=5 and returns at most 10 cookies.
+const targetParamPrefix = "target."
+
+// controlParams are the query parameters GET /fetch-cookies/ reads itself.
+// Any other parameter belongs to the target URL given in the path. Several
+// are generic names, e.g. format, path or limit, that a target may use too;
+// such a target either goes in the url parameter or writes its parameter
+// with targetParamPrefix. New GET options must be added here.
+var controlParams = map[string]bool{
+	"url":                      true,
+	"headless":                 true,
+	"skip_network_idle":        true,
+	"accept_language":          true,
+	"accept_encoding":          true,
+	"clear_cookies":            true,
+	"clear_except":             true,
+	"include_page_info":        true,
+	"referrer":                 true,
+	"origin":                   true,
+	"fingerprint":              true,
+	"scroll":                   true,
+	"scroll_max":               true,
+	"scroll_delay_ms":          true,
+	"name_pattern":             true,
+	"name_prefix":              true,
+	"name_contains":            true,
+	"value_pattern":            true,
+	"case_insensitive":         true,
+	"path":                     true,
+	"only_persistent":          true,
+	"only_session":             true,
+	"auth_only":                true,
+	"retry_if_empty":           true,
+	"retry_max_attempts":       true,
+	"retry_delay_ms":           true,
+	"retry_require":            true,
+	"encode_binary_values":     true,
+	"profile":                  true,
+	"wait_resource":            true,
+	"wait_selector":            true,
+	"wait_min_cookies":         true,
+	"wait_min_cookies_pattern": true,
+	"warmup_url":               true,
+	"no_dedup":                 true,
+	"best_effort":              true,
+	"auto_accept_cookies":      true,
+	"lifecycle_event":          true,
+	"network_idle_inflight":    true,
+	"new_context":              true,
+	"after_event_pattern":      true,
+	"after_event_delay_ms":     true,
+	"extract_globals":          true,
+	"include_indexeddb":        true,
+	"include_html":             true,
+	"html_max_bytes":           true,
+	"timeout_ms":               true,
+	"format":                   true,
+	"summary":                  true,
+	"envelope":                 true,
+	"include_header":           true,
+	"echo_params":              true,
+	"expiry_override":          true,
+	"raw":                      true,
+	"rewrite_domain":           true,
+	"limit":                    true,
+	"offset":                   true,
+	"fields":                   true,
+	"max_value_len":            true,
+}
+
+// getTargetURL returns the URL a GET request asks for. It is either the
+// url query parameter, or the rest of the path as sent, still escaped, with
+// the query parameters that aren't ours appended, so
+// /fetch-cookies/site.com/page?a=b&headless=false targets site.com/page?a=b.
+func getTargetURL(r *http.Request) (string, error) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/fetch-cookies/")
+	param := r.URL.Query().Get("url")
+	switch {
+	case param != "" && path != "":
+		return "", fmt.Errorf("give the target URL either in the path or as the url parameter, not both")
+	case param != "":
+		return param, nil
+	case path == "":
+		return "", fmt.Errorf("missing URL in path or url parameter")
+	}
+	if query := targetQuery(r.URL.RawQuery); query != "" {
+		path += "?" + query
+	}
+	return path, nil
+}
+
+// targetQuery drops our control parameters from a raw query string, keeping
+// the others in their original order and encoding. Parameters written with
+// targetParamPrefix are kept without it.
+func targetQuery(rawQuery string) string {
+	var kept []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		if own, ok := strings.CutPrefix(pair, targetParamPrefix); ok && own != "" {
+			kept = append(kept, own)
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && controlParams[name] {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tempDirRegistry owns the temp dirs fetches create, such as profile copies.
+// Each server instance keeps them in a dir of its own, named after its PID,
+// under one shared base dir, so that whatever a killed process left behind
+// can be found and removed on the next start without touching the dirs of
+// instances still running.
+type tempDirRegistry struct {
+	mu   sync.Mutex
+	base string
+	live map[string]bool
+}
+
+var tempDirs = &tempDirRegistry{live: make(map[string]bool)}
+
+// instanceDirPrefix starts the name of every instance's dir in the base.
+const instanceDirPrefix = "pid-"
+
+// defaultTempDir is used when server.temp_dir is not set.
+func defaultTempDir() string {
+	return filepath.Join(os.TempDir(), "cookieapi")
+}
+
+// instanceDir is this process's dir under base.
+func instanceDir(base string) string {
+	return filepath.Join(base, instanceDirPrefix+strconv.Itoa(os.Getpid()))
+}
+
+// init sets up this instance's dir and removes those of instances whose
+// process is gone. Other entries of the base are left alone.
+func (t *tempDirRegistry) init(config Config) error {
+	base := config.Server.TempDir
+	if base == "" {
+		base = defaultTempDir()
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read temp dir %s: %v", base, err)
+	}
+	removed := 0
+	for _, e := range entries {
+		pid, err := strconv.Atoi(strings.TrimPrefix(e.Name(), instanceDirPrefix))
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), instanceDirPrefix) || err != nil {
+			continue
+		}
+		// A dir with our own PID is a leftover of an earlier process that
+		// happened to get the same one.
+		if pid != os.Getpid() && processAlive(pid) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(base, e.Name())); err != nil {
+			log.Printf("Failed to remove leftover temp dir %s: %v", e.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Removed the temp dirs of %d exited instances from %s", removed, base)
+	}
+	dir := instanceDir(base)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create temp dir %s: %v", dir, err)
+	}
+
+	t.mu.Lock()
+	t.base = dir
+	t.mu.Unlock()
+	return nil
+}
+
+// create makes a new temp dir named after pattern, as os.MkdirTemp does.
+func (t *tempDirRegistry) create(pattern string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	base := t.base
+	if base == "" {
+		base = instanceDir(defaultTempDir())
+		if err := os.MkdirAll(base, 0o700); err != nil {
+			return "", err
+		}
+	}
+	dir, err := os.MkdirTemp(base, pattern)
+	if err != nil {
+		return "", err
+	}
+	t.live[dir] = true
+	return dir, nil
+}
+
+func (t *tempDirRegistry) remove(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to remove temp dir %s: %v", dir, err)
+	}
+	t.mu.Lock()
+	delete(t.live, dir)
+	t.mu.Unlock()
+}
+
+// keep stops tracking dir so it survives shutdown. It is still removed with
+// the other leftovers once a later start finds this process gone.
+func (t *tempDirRegistry) keep(dir string) {
+	t.mu.Lock()
+	delete(t.live, dir)
+	t.mu.Unlock()
+}
+
+// removeAll deletes every temp dir still registered, on shutdown.
+func (t *tempDirRegistry) removeAll() {
+	t.mu.Lock()
+	dirs := make([]string, 0, len(t.live))
+	for dir := range t.live {
+		dirs = append(dirs, dir)
+	}
+	t.mu.Unlock()
+	for _, dir := range dirs {
+		t.remove(dir)
+	}
+	// The instance dir goes too, unless keep left something in it.
+	t.mu.Lock()
+	base := t.base
+	t.mu.Unlock()
+	if base != "" {
+		os.Remove(base)
+	}
+}
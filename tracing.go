@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of requests and fetches. Until setupTracing
+// installs an exporter it is OpenTelemetry's no-op default.
+var tracer = otel.Tracer("github.com/stashme/cookieapi")
+
+// setupTracing exports spans over OTLP/HTTP to server.otel_endpoint, such
+// as http://collector:4318. The returned func flushes what is still
+// buffered; it is a no-op when tracing is off.
+func setupTracing(config Config) (shutdown func(context.Context) error, err error) {
+	endpoint := config.Server.OtelEndpoint
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the OTLP exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "cookieapi"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// withTracing starts the root span of every request, as a child of the
+// caller's span when it sends a traceparent header.
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		// Target URLs are part of /fetch-cookies/ paths, so they are
+		// left out of the span name to keep its cardinality low.
+		route := r.URL.Path
+		if strings.HasPrefix(route, "/fetch-cookies/") {
+			route = "/fetch-cookies/"
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traced runs action in a child span of the fetch named after its stage.
+func traced(stage string, action chromedp.ActionFunc) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		ctx, span := tracer.Start(ctx, stage)
+		err := action(ctx)
+		endSpan(span, err)
+		return err
+	}
+}
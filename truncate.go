@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// truncationMark is appended to values cut by max_value_len.
+const truncationMark = "…"
+
+func parseMaxValueLen(r *http.Request) (int, error) {
+	if r.URL.Query().Get("max_value_len") == "" {
+		return 0, nil
+	}
+	n := queryInt(r, "max_value_len")
+	if n < 1 {
+		return 0, fmt.Errorf("max_value_len must be a positive number of bytes")
+	}
+	return n, nil
+}
+
+// truncateValues cuts the values of the exported cookies longer than
+// ?max_value_len bytes, on a UTF-8 boundary, appends truncationMark and
+// records their original length. The browser's cookies are left alone.
+func truncateValues(r *http.Request, cookies []Cookie) {
+	limit, err := parseMaxValueLen(r)
+	if err != nil || limit == 0 {
+		return
+	}
+	for i := range cookies {
+		c := &cookies[i]
+		if len(c.Value) <= limit {
+			continue
+		}
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(c.Value[cut]) {
+			cut--
+		}
+		c.OriginalLength = len(c.Value)
+		c.Value = c.Value[:cut] + truncationMark
+		c.Truncated = true
+	}
+}
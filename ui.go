@@ -0,0 +1,25 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed ui/index.html
+var uiPage []byte
+
+// handleUI serves the web form at / when server.ui_enabled is set. The form
+// posts to /fetch-cookies/ from the browser, so with server.api_key set the
+// browser's basic auth prompt supplies the key for both.
+func handleUI(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.URL.Path != "/" || !config.Server.UIEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sendError(w, "Only GET requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
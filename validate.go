@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// validateRequest is the single place a fetch request's options are checked,
+// on their own and in combination, before any browser is started. Errors are
+// meant to be returned to the client as a 400.
+func validateRequest(r *http.Request, payload RequestPayload, config Config) error {
+	if err := validatePayload(payload); err != nil {
+		return err
+	}
+	if err := validateBatchOptions(payload, config); err != nil {
+		return err
+	}
+	if err := validateChromeFlags(payload.ChromeFlags, config); err != nil {
+		return err
+	}
+	if err := validateNavigation(payload, config); err != nil {
+		return err
+	}
+	return validateOutput(r, payload, config)
+}
+
+// validatePayload checks the fetch options, which come from the JSON body or
+// the GET query string.
+func validatePayload(payload RequestPayload) error {
+	if (payload.URL == "") == (len(payload.URLs) == 0) {
+		return fmt.Errorf("exactly one of url or urls is required")
+	}
+	if len(payload.URLs) > maxBatchURLs {
+		return fmt.Errorf("at most %d urls are allowed per batch", maxBatchURLs)
+	}
+	if len(payload.URLs) > 0 && payload.Interactive {
+		return fmt.Errorf("interactive can't be combined with urls")
+	}
+	if payload.Interactive && payload.Pattern == "" && payload.WaitSelector == "" {
+		return fmt.Errorf("interactive requires a pattern or wait_selector")
+	}
+	if err := validateTimeoutMS(payload.TimeoutMS, payload.Interactive); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(payload.Pattern); err != nil {
+		return fmt.Errorf("invalid regex pattern: %v", err)
+	}
+	if payload.AcceptLanguage != "" {
+		if err := validateAcceptLanguage(payload.AcceptLanguage); err != nil {
+			return err
+		}
+	}
+	if payload.AcceptEncoding != "" {
+		if err := validateAcceptEncoding(payload.AcceptEncoding); err != nil {
+			return err
+		}
+	}
+	if payload.Referrer != "" {
+		if err := validateReferrer(payload.Referrer); err != nil {
+			return err
+		}
+	}
+	if payload.Origin != "" {
+		if err := validateOrigin(payload.Origin); err != nil {
+			return err
+		}
+	}
+	if payload.WarmupURL != "" {
+		u, err := url.Parse(payload.WarmupURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid warmup_url %q: expected an absolute http(s) URL", payload.WarmupURL)
+		}
+	}
+	if payload.LifecycleEvent != "" {
+		if err := validateLifecycleEvent(payload.LifecycleEvent); err != nil {
+			return err
+		}
+	}
+	if err := validateClickSelectors(payload.ClickSelectors); err != nil {
+		return err
+	}
+	if err := validateWaitMinCookies(payload.WaitMinCookies, payload.WaitMinCookiesPattern); err != nil {
+		return err
+	}
+	if err := validateSeedCookies(payload.SeedCookies); err != nil {
+		return err
+	}
+	if err := validateHTMLMaxBytes(payload); err != nil {
+		return err
+	}
+	if err := validateExtractGlobals(payload.ExtractGlobals); err != nil {
+		return err
+	}
+	if payload.Scroll != nil {
+		if err := validateScroll(*payload.Scroll); err != nil {
+			return err
+		}
+	}
+	if payload.Fingerprint != nil {
+		if err := validateFingerprint(*payload.Fingerprint); err != nil {
+			return err
+		}
+	}
+	if payload.RetryIfEmpty != nil {
+		if payload.Interactive {
+			return fmt.Errorf("retry_if_empty can't be combined with interactive")
+		}
+		if err := validateRetry(*payload.RetryIfEmpty); err != nil {
+			return err
+		}
+	}
+	if payload.AfterEvent != nil {
+		if err := validateAfterEvent(*payload.AfterEvent); err != nil {
+			return err
+		}
+	}
+	if _, err := cookieFilters(payload); err != nil {
+		return err
+	}
+	if payload.NewContext && payload.ClearCookies {
+		return fmt.Errorf("clear_cookies can't be combined with new_context, which already starts without cookies")
+	}
+	if len(payload.ClearExcept) > 0 && !payload.ClearCookies {
+		return fmt.Errorf("clear_except requires clear_cookies")
+	}
+	if payload.NetworkIdleInflight < 0 || payload.NetworkIdleInflight > maxNetworkIdleInflight {
+		return fmt.Errorf("network_idle_inflight must be between 0 and %d", maxNetworkIdleInflight)
+	}
+	if payload.NetworkIdleInflight > 0 && (payload.SkipNetworkIdle || payload.LifecycleEvent != "") {
+		return fmt.Errorf("network_idle_inflight tunes the network idle wait, so it can't be combined with skip_network_idle or lifecycle_event")
+	}
+	if payload.ClickOptional && len(payload.ClickSelectors) == 0 {
+		return fmt.Errorf("click_optional requires click_selectors")
+	}
+	return nil
+}
+
+// validateOutput checks the query parameters that shape the response. Most
+// of them only make sense for a single JSON response, so they exclude each
+// other and batch requests.
+func validateOutput(r *http.Request, payload RequestPayload, config Config) error {
+	if _, err := negotiateFormat(r, config); err != nil {
+		return err
+	}
+	explicitFormat := r.URL.Query().Get("format")
+	summary := queryBool(r, "summary")
+	envelope := queryBool(r, "envelope") || queryBool(r, "include_header") || queryBool(r, "echo_params")
+	raw := queryBool(r, "raw")
+
+	expiryOverride := r.URL.Query().Get("expiry_override")
+	if expiryOverride != "" {
+		if _, err := parseExpiryOverride(expiryOverride, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	rewriteDomain := r.URL.Query().Get("rewrite_domain")
+	if rewriteDomain != "" {
+		if _, err := parseRewriteDomain(rewriteDomain); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := pageParams(r); err != nil {
+		return err
+	}
+	maxValueLen, err := parseMaxValueLen(r)
+	if err != nil {
+		return err
+	}
+	fields := r.URL.Query().Get("fields")
+	if fields != "" {
+		if _, err := parseFields(fields); err != nil {
+			return err
+		}
+	}
+
+	if len(payload.URLs) > 0 {
+		if expiryOverride != "" || rewriteDomain != "" {
+			return fmt.Errorf("expiry_override and rewrite_domain aren't supported for batch requests")
+		}
+		if paged(r) || fields != "" || maxValueLen > 0 {
+			return fmt.Errorf("limit, offset, fields and max_value_len aren't supported for batch requests")
+		}
+		if explicitFormat != "" && explicitFormat != "json" {
+			return fmt.Errorf("format=%s isn't supported for batch requests", explicitFormat)
+		}
+		if summary || envelope || raw {
+			return fmt.Errorf("summary, envelope, include_header, echo_params and raw aren't supported for batch requests")
+		}
+		return nil
+	}
+	if summary && envelope {
+		return fmt.Errorf("summary replaces the cookie list, so it can't be combined with envelope, include_header or echo_params")
+	}
+	if raw && (summary || envelope) {
+		return fmt.Errorf("raw returns the bare CDP cookie array, so it can't be combined with summary, envelope, include_header or echo_params")
+	}
+	if raw && (expiryOverride != "" || rewriteDomain != "" || payload.EncodeBinaryValues) {
+		return fmt.Errorf("raw cookies are returned as CDP reports them, so they can't be combined with expiry_override, rewrite_domain or encode_binary_values")
+	}
+	if raw && paged(r) {
+		return fmt.Errorf("limit and offset can't be combined with raw")
+	}
+	if raw && maxValueLen > 0 {
+		return fmt.Errorf("max_value_len can't be combined with raw")
+	}
+	if fields != "" && (summary || raw) {
+		return fmt.Errorf("fields selects cookie fields, so it can't be combined with summary or raw")
+	}
+	// An explicit non-JSON format conflicts with JSON-only options; a
+	// server.default_format merely gives way to them.
+	if explicitFormat != "" && explicitFormat != "json" {
+		if summary {
+			return fmt.Errorf("summary can't be combined with format=%s", explicitFormat)
+		}
+		if envelope {
+			return fmt.Errorf("envelope, include_header and echo_params require format=json")
+		}
+		if raw {
+			return fmt.Errorf("raw requires format=json")
+		}
+		if fields != "" {
+			return fmt.Errorf("fields requires format=json")
+		}
+		if maxValueLen > 0 {
+			return fmt.Errorf("max_value_len requires format=json, where truncated values are flagged")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// errWaitTimeout is wrapped by the errors of waits that ran out of time, as
+// opposed to ones that failed, which best_effort tells apart.
+var errWaitTimeout = errors.New("timeout")
+
+// Wait conditions reported as matched_by.
+const (
+	matchedPattern  = "pattern"
+	matchedSelector = "selector"
+)
+
+// waitForSelector waits until an element matching the CSS selector is
+// visible.
+func waitForSelector(ctx context.Context, selector string, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := chromedp.WaitVisible(selector, chromedp.ByQuery).Do(waitCtx); err != nil {
+		if ctx.Err() == nil && waitCtx.Err() != nil {
+			return fmt.Errorf("%w waiting for selector %s after %v", errWaitTimeout, selector, timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// waitForPatternOrSelector races waitForURLPattern against waitForSelector
+// and returns the condition that was met first. The other wait is
+// cancelled; an error is only returned once both have failed.
+func waitForPatternOrSelector(ctx context.Context, poll pollTiming, pattern, selector string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		condition string
+		err       error
+	}
+	// Buffered so the losing goroutine can always finish after we return.
+	done := make(chan outcome, 2)
+	go func() { done <- outcome{matchedPattern, waitForURLPattern(ctx, poll, pattern, timeout)} }()
+	go func() { done <- outcome{matchedSelector, waitForSelector(ctx, selector, timeout)} }()
+
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		o := <-done
+		if o.err == nil {
+			return o.condition, nil
+		}
+		if firstErr == nil {
+			firstErr = o.err
+		}
+	}
+	return "", firstErr
+}